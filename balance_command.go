@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// BalanceCommand simulates how evenly each Balancer splits a CSV file of query specifications
+// across a range of worker counts, without executing any queries.
+type BalanceCommand struct {
+	// CSV is the source of query specifications to balance, in the InputFormat.
+	CSV io.Reader
+
+	// InputFormat is the format of the query specifications: "csv" or "jsonl". Optional, defaults to
+	// "jsonl" if the CSV is a file with a .jsonl or .ndjson extension, and "csv" otherwise.
+	InputFormat string
+
+	// Balancers are the names of the balancers to simulate. Optional, defaults to every balancer.
+	Balancers []string
+
+	// Workers are the numbers of workers to simulate.
+	Workers []int
+
+	// Output is the destination of the simulation results. Optional, defaults to stdout.
+	Output io.Writer
+}
+
+// NewBalanceCommandFromCLI reads the configuration of a BalanceCommand from the arguments following "balance".
+func NewBalanceCommandFromCLI(args []string) (*BalanceCommand, error) {
+	flags := flag.NewFlagSet("balance", flag.ExitOnError)
+	names := flags.String("balancers", strings.Join(append(balancerNames(), "lpt"), ","), "comma-separated names of the balancers to simulate")
+	workers := flags.String("workers", "2,4,8,16", "comma-separated numbers of workers to simulate")
+	format := flags.String("input-format", "", "format of the query specifications: csv or jsonl (defaults to jsonl for .jsonl files, csv otherwise)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ts-query-workers balance [options] [CSV_OR_JSONL_FILENAME]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cmd := &BalanceCommand{
+		CSV:         os.Stdin,
+		Balancers:   strings.Split(*names, ","),
+		InputFormat: *format,
+	}
+
+	for _, w := range strings.Split(*workers, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid number of workers %q", w)
+		}
+		cmd.Workers = append(cmd.Workers, n)
+	}
+
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		cmd.CSV = f
+	}
+
+	return cmd, nil
+}
+
+// Exec balances the queries with every balancer and number of workers, and prints a table of the results.
+func (c *BalanceCommand) Exec(ctx context.Context) error {
+	if closer, ok := c.CSV.(io.Closer); ok && c.CSV != os.Stdin {
+		defer closer.Close()
+	}
+
+	if len(c.Workers) == 0 {
+		return errors.New("balance requires at least one number of workers")
+	}
+
+	var filename string
+	if f, ok := c.CSV.(*os.File); ok {
+		filename = f.Name()
+	}
+	format, err := inputFormat(c.InputFormat, filename)
+	if err != nil {
+		return err
+	}
+
+	queries, err := queriesFromInput(c.CSV, format, CSVOptions{}, device.MinMaxCPUTemplate)
+	if err != nil {
+		return err
+	}
+
+	names := c.Balancers
+	if len(names) == 0 {
+		names = append(balancerNames(), "lpt")
+	}
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	fmt.Fprintf(out, "Balancing %d queries of %d hosts:\n\n", len(queries), countHosts(queries))
+	fmt.Fprintln(out, "|   Balancer | Workers |     CV | Max/mean | Range CV | Range max/mean | Queries per bucket | Hosts per bucket |")
+	fmt.Fprintln(out, "|------------|---------|--------|----------|----------|----------------|--------------------|------------------|")
+
+	for _, name := range names {
+		for _, n := range c.Workers {
+			assignments, err := assignQueries(queries, n, name, withSpecWeight(TimeRangeWeight))
+			if err != nil {
+				return fmt.Errorf("balancer %s failed: %w", name, err)
+			}
+			b, err := newBalanceSummary(queries, assignments, n)
+			if err != nil {
+				return err
+			}
+			counts := make([]float64, n)
+			for i, q := range b.queries {
+				counts[i] = float64(q)
+			}
+			fmt.Fprintf(out, "| %10s | %7d | %6.3f | %8.3f | %8.3f | %14.3f | %18s | %16s |\n",
+				name, n, cv(counts), maxMeanRatio(counts), cv(b.weights), maxMeanRatio(b.weights), joinInts(b.queries), joinInts(b.hosts))
+		}
+	}
+
+	return nil
+}
+
+// BalanceSummary is the number of queries, their total weight and the number of distinct hosts assigned to each bucket.
+type balanceSummary struct {
+	queries []int
+	hosts   []int
+
+	// Weights are the total TimeRangeWeight of the queries of each bucket, which the lpt balancer balances.
+	weights []float64
+}
+
+func newBalanceSummary(queries []device.Query, assignments []int, n int) (balanceSummary, error) {
+	b := balanceSummary{queries: make([]int, n), hosts: make([]int, n), weights: make([]float64, n)}
+	seen := make([]map[string]bool, n)
+	for i, bucket := range assignments {
+		weight, err := TimeRangeWeight(queries[i])
+		if err != nil {
+			return b, err
+		}
+		b.queries[bucket]++
+		b.weights[bucket] += weight
+		if seen[bucket] == nil {
+			seen[bucket] = map[string]bool{}
+		}
+		if host := queries[i].Host(); !seen[bucket][host] {
+			seen[bucket][host] = true
+			b.hosts[bucket]++
+		}
+	}
+	return b, nil
+}
+
+func mean(xs []float64) float64 {
+	total := 0.0
+	for _, x := range xs {
+		total += x
+	}
+	return total / float64(len(xs))
+}
+
+// CV returns the coefficient of variation of the buckets: the standard deviation divided by the mean,
+// which is 0 when every bucket is the same.
+func cv(buckets []float64) float64 {
+	m := mean(buckets)
+	if m == 0 {
+		return 0
+	}
+	variance := 0.0
+	for _, x := range buckets {
+		variance += (x - m) * (x - m)
+	}
+	return math.Sqrt(variance/float64(len(buckets))) / m
+}
+
+// MaxMeanRatio returns the largest bucket divided by the mean,
+// which bounds how much longer the busiest worker takes than an even split.
+func maxMeanRatio(buckets []float64) float64 {
+	m := mean(buckets)
+	if m == 0 {
+		return 0
+	}
+	max := 0.0
+	for _, x := range buckets {
+		if x > max {
+			max = x
+		}
+	}
+	return max / m
+}
+
+func countHosts(queries []device.Query) int {
+	hosts := map[string]bool{}
+	for _, q := range queries {
+		hosts[q.Host()] = true
+	}
+	return len(hosts)
+}
+
+func joinInts(xs []int) string {
+	strs := make([]string, len(xs))
+	for i, x := range xs {
+		strs[i] = strconv.Itoa(x)
+	}
+	return strings.Join(strs, " ")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestBalanceCommand(t *testing.T) {
+	f, err := os.Open("datafiles/query_params.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := &BalanceCommand{CSV: f, Workers: []int{2, 4}, Output: &out}
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A header line, then a row per balancer and number of workers.
+	rows := strings.Count(out.String(), "\n| ")
+	if expect := 1 + 2*(len(balancers)+1); rows != expect {
+		t.Errorf("expected %d table rows but got %d:\n%s", expect, rows, out.String())
+	}
+	if !strings.Contains(out.String(), "Balancing 200 queries") {
+		t.Errorf("expected the number of queries to be reported:\n%s", out.String())
+	}
+}
+
+func TestBalanceSummary(t *testing.T) {
+	start := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	query := func(host string, minutes int) device.Query {
+		return device.MinMaxCPUQuery{Hostname: host, StartTime: start, EndTime: start.Add(time.Duration(minutes) * time.Minute)}
+	}
+	queries := []device.Query{
+		query("host_000001", 10),
+		query("host_000001", 10),
+		query("host_000002", 10),
+		query("host_000003", 30),
+	}
+	b, err := newBalanceSummary(queries, []int{0, 0, 0, 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if joinInts(b.queries) != "3 1" || joinInts(b.hosts) != "2 1" {
+		t.Errorf("expected 3 and 1 queries of 2 and 1 hosts but got %v queries of %v hosts", b.queries, b.hosts)
+	}
+	if r := maxMeanRatio([]float64{3, 1}); r != 1.5 {
+		t.Errorf("expected max/mean 1.5 but got %f", r)
+	}
+	if cv := cv([]float64{3, 1}); cv != 0.5 {
+		t.Errorf("expected CV 0.5 but got %f", cv)
+	}
+
+	// The buckets have the same number of minutes, so they are balanced by weight.
+	if cv, r := cv(b.weights), maxMeanRatio(b.weights); cv != 0 || r != 1 {
+		t.Errorf("expected buckets of the same weight but got CV %f and max/mean %f of %v", cv, r, b.weights)
+	}
+}
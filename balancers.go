@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"hash"
+	"hash/fnv"
 	"math/rand"
+	"sort"
+	"strings"
 
 	"github.com/sbward/ts-query-workers/device"
 )
@@ -49,14 +54,230 @@ func NewHashBalancer(h hash.Hash32) Balancer {
 	}
 }
 
-// NewQueryHostnameBalancer returns a Balancer that accepts *device.MinMaxCPUQuery values
-// and uses the Hostname of the query as the input value into another Balancer.
+// NewQueryHostnameBalancer returns a Balancer that accepts device.Query values
+// and uses the Host of the query as the input value into another Balancer.
 func NewQueryHostnameBalancer(next Balancer) Balancer {
 	return func(value any, buckets int) (int, error) {
-		query, ok := value.(*device.MinMaxCPUQuery)
+		query, ok := value.(device.Query)
 		if !ok {
-			return 0, fmt.Errorf("value must be *device.MinMaxCPUQuery but got %T", value)
+			return 0, fmt.Errorf("value must be device.Query but got %T", value)
 		}
-		return next(query.Hostname, buckets)
+		return next(query.Host(), buckets)
 	}
 }
+
+// DefaultVirtualNodes is the number of points of each bucket on the ring of a consistent-hash balancer.
+const DefaultVirtualNodes = 160
+
+// NewConsistentHashBalancer returns a Balancer that assigns a string value to the first of a number of virtual nodes
+// per bucket after its hash on a ring, so few values move when the number of buckets changes.
+func NewConsistentHashBalancer(h hash.Hash32, virtualNodes int) Balancer {
+	rings := map[int]hashRing{}
+	return func(value any, buckets int) (int, error) {
+		str, ok := value.(string)
+		if !ok {
+			return 0, fmt.Errorf("value must be a string but got %T", value)
+		}
+		ring, ok := rings[buckets]
+		if !ok {
+			ring = newHashRing(h, buckets, virtualNodes)
+			rings[buckets] = ring
+		}
+		return ring.bucket(hashString(h, str)), nil
+	}
+}
+
+// HashRing is a sorted ring of virtual nodes, each of which belongs to a bucket.
+type hashRing struct {
+	hashes  []uint32
+	buckets []int
+}
+
+func newHashRing(h hash.Hash32, buckets, virtualNodes int) hashRing {
+	type node struct {
+		hash   uint32
+		bucket int
+	}
+	nodes := make([]node, 0, buckets*virtualNodes)
+	for bucket := 0; bucket < buckets; bucket++ {
+		for v := 0; v < virtualNodes; v++ {
+			nodes = append(nodes, node{hashString(h, fmt.Sprintf("bucket-%d-node-%d", bucket, v)), bucket})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].hash < nodes[j].hash })
+
+	ring := hashRing{hashes: make([]uint32, len(nodes)), buckets: make([]int, len(nodes))}
+	for i, n := range nodes {
+		ring.hashes[i], ring.buckets[i] = n.hash, n.bucket
+	}
+	return ring
+}
+
+// Bucket returns the bucket of the first node at or after the hash, wrapping around the ring.
+func (r hashRing) bucket(hash uint32) int {
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.buckets[i]
+}
+
+// NewRendezvousBalancer returns a Balancer that hashes a string value together with each bucket index,
+// then selects the bucket with the highest hash (highest random weight). When a bucket is added,
+// only the values for which the new bucket has the highest hash move to it.
+func NewRendezvousBalancer(h hash.Hash32) Balancer {
+	return func(value any, buckets int) (int, error) {
+		defer h.Reset()
+		str, ok := value.(string)
+		if !ok {
+			return 0, fmt.Errorf("value must be a string but got %T", value)
+		}
+		best, bestWeight := 0, uint32(0)
+		var index [4]byte
+		for bucket := 0; bucket < buckets; bucket++ {
+			h.Reset()
+			h.Write([]byte(str))
+			binary.BigEndian.PutUint32(index[:], uint32(bucket))
+			h.Write(index[:])
+			if weight := mix32(h.Sum32()); bucket == 0 || weight > bestWeight {
+				best, bestWeight = bucket, weight
+			}
+		}
+		return best, nil
+	}
+}
+
+func hashString(h hash.Hash32, s string) uint32 {
+	defer h.Reset()
+	h.Write([]byte(s))
+	return mix32(h.Sum32())
+}
+
+// Mix32 scrambles the bits of a hash, since hashes such as FNV of similar strings differ mostly in their low bits.
+// It is the finalizer of MurmurHash3.
+func mix32(x uint32) uint32 {
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Balancers maps the name of each Balancer of query hostnames to a function that creates it.
+var balancers = map[string]func() Balancer{
+	"random":     func() Balancer { return RandomBalancer },
+	"host-id":    func() Balancer { return HostIDBalancer },
+	"hash":       func() Balancer { return NewHashBalancer(fnv.New32()) },
+	"ring":       func() Balancer { return NewConsistentHashBalancer(fnv.New32a(), DefaultVirtualNodes) },
+	"rendezvous": func() Balancer { return NewRendezvousBalancer(fnv.New32a()) },
+}
+
+// NewBalancer returns the named Balancer of query hostnames, wrapped to accept device.Query values.
+func NewBalancer(name string) (Balancer, error) {
+	newBalancer, ok := balancers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown balancer %q (expected one of: %s or lpt)", name, strings.Join(balancerNames(), ", "))
+	}
+	return NewQueryHostnameBalancer(newBalancer()), nil
+}
+
+func balancerNames() []string {
+	names := make([]string, 0, len(balancers))
+	for name := range balancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AssignQueries returns the index of the bucket assigned to each query out of N buckets by the named Balancer,
+// or by AssignLPT with the weight if the name is "lpt".
+func assignQueries(queries []device.Query, n int, name string, weight QueryWeight) ([]int, error) {
+	if name == "lpt" {
+		return AssignLPT(queries, n, weight)
+	}
+	balancer, err := NewBalancer(name)
+	if err != nil {
+		return nil, err
+	}
+	return Assign(queries, n, balancer)
+}
+
+// QueryWeight estimates the relative time it takes to execute a query.
+type QueryWeight func(q device.Query) (float64, error)
+
+var _ QueryWeight = TimeRangeWeight
+
+// TimeRangeWeight weights a query by the length of its time range in minutes,
+// which is proportional to the number of rows and time buckets it aggregates.
+func TimeRangeWeight(q device.Query) (float64, error) {
+	start, end := q.TimeRange()
+	if end.Before(start) {
+		return 0, fmt.Errorf("query time range ends before it starts: %s", q)
+	}
+	return end.Sub(start).Minutes(), nil
+}
+
+// NewCostWeight returns a QueryWeight of the total cost estimated by the query planner,
+// which plans each query with EXPLAIN without executing it.
+func NewCostWeight(ctx context.Context, tx device.QuerierCtx) QueryWeight {
+	return func(q device.Query) (float64, error) {
+		stats, err := q.Explain(ctx, tx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to explain query %s: %w", q, err)
+		}
+		return float64(stats.Cost), nil
+	}
+}
+
+// NewLeastLoadedBalancer returns a Balancer that accepts float64 weights, and assigns each weight to the bucket
+// with the least total weight assigned so far. Weights are assigned greedily in the order they are balanced,
+// so the buckets are most even when the heaviest weights are balanced first, as AssignLPT does.
+func NewLeastLoadedBalancer() Balancer {
+	var loads []float64
+	return func(value any, buckets int) (int, error) {
+		weight, ok := value.(float64)
+		if !ok {
+			return 0, fmt.Errorf("value must be float64 but got %T", value)
+		}
+		if len(loads) != buckets {
+			loads = make([]float64, buckets)
+		}
+		least := 0
+		for bucket, load := range loads {
+			if load < loads[least] {
+				least = bucket
+			}
+		}
+		loads[least] += weight
+		return least, nil
+	}
+}
+
+// AssignLPT returns the index of the bucket assigned to each query out of N buckets, by the longest-processing-time-first
+// rule: queries are balanced in order of decreasing weight, each to the bucket with the least total weight so far.
+// Unlike hashing the hostname, queries of the same host may be assigned to different buckets.
+func AssignLPT(queries []device.Query, n int, weight QueryWeight) ([]int, error) {
+	weights := make([]float64, len(queries))
+	order := make([]int, len(queries))
+	for i, q := range queries {
+		w, err := weight(q)
+		if err != nil {
+			return nil, err
+		}
+		weights[i], order[i] = w, i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return weights[order[i]] > weights[order[j]] })
+
+	balance := NewLeastLoadedBalancer()
+	assignments := make([]int, len(queries))
+	for _, i := range order {
+		bucket, err := balance(weights[i], n)
+		if err != nil {
+			return nil, err
+		}
+		assignments[i] = bucket
+	}
+	return assignments, nil
+}
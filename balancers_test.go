@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math"
 	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
 )
 
 func TestHostIDBalancer(t *testing.T) {
@@ -34,3 +39,180 @@ func TestHostIDBalancer(t *testing.T) {
 		}
 	}
 }
+
+// TestHosts returns n hostnames in the format of the dataset.
+func testHosts(n int) []string {
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host_%06d", i)
+	}
+	return hosts
+}
+
+// KeyMovement returns the fraction of hosts assigned to a different bucket when the bucket count changes from n to m,
+// and whether every moved host moved to one of the added buckets.
+func keyMovement(t *testing.T, balance Balancer, hosts []string, n, m int) (float64, bool) {
+	t.Helper()
+	before, err := Assign(hosts, n, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Assign(hosts, m, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, onlyToNew := 0, true
+	for i := range hosts {
+		if before[i] != after[i] {
+			moved++
+			onlyToNew = onlyToNew && after[i] >= n
+		}
+	}
+	return float64(moved) / float64(len(hosts)), onlyToNew
+}
+
+// Skew returns the ratio of the largest bucket to the mean bucket size.
+func skew(t *testing.T, balance Balancer, hosts []string, n int) float64 {
+	t.Helper()
+	buckets, err := Buckets(hosts, n, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := 0
+	for _, bucket := range buckets {
+		if len(bucket) > max {
+			max = len(bucket)
+		}
+	}
+	return float64(max) / (float64(len(hosts)) / float64(n))
+}
+
+func TestStableBalancers(t *testing.T) {
+	hosts := testHosts(10000)
+
+	tests := []struct {
+		name    string
+		balance Balancer
+		maxSkew float64
+	}{
+		{"ring", NewConsistentHashBalancer(fnv.New32a(), DefaultVirtualNodes), 1.3},
+		{"rendezvous", NewRendezvousBalancer(fnv.New32a()), 1.15},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Adding a fifth bucket should move about a fifth of the hosts, all to the new bucket.
+			moved, onlyToNew := keyMovement(t, test.balance, hosts, 4, 5)
+			t.Logf("%.1f%% of hosts moved from 4 to 5 buckets", moved*100)
+			if moved < 0.1 || moved > 0.3 {
+				t.Errorf("expected about 20%% of hosts to move but %.1f%% moved", moved*100)
+			}
+			if !onlyToNew {
+				t.Error("expected hosts to move only to the added bucket")
+			}
+
+			for _, n := range []int{2, 5, 8, 16} {
+				s := skew(t, test.balance, hosts, n)
+				t.Logf("max/mean bucket size with %d buckets: %.3f", n, s)
+				if s > test.maxSkew {
+					t.Errorf("expected max/mean bucket size with %d buckets to be at most %.2f but got %.3f", n, test.maxSkew, s)
+				}
+			}
+		})
+	}
+
+	// For comparison, the modulo of a hash moves most hosts.
+	moved, _ := keyMovement(t, NewHashBalancer(fnv.New32()), hosts, 4, 5)
+	t.Logf("%.1f%% of hosts moved from 4 to 5 buckets with the hash balancer", moved*100)
+}
+
+func TestNewBalancer(t *testing.T) {
+	for _, name := range balancerNames() {
+		if _, err := NewBalancer(name); err != nil {
+			t.Errorf("failed to create balancer %s: %s", name, err)
+		}
+	}
+	if _, err := NewBalancer("nope"); err == nil {
+		t.Error("expected an error for an unknown balancer")
+	}
+}
+
+// MaxLoad returns the largest total weight assigned to a bucket.
+func maxLoad(assignments []int, weights []float64, n int) float64 {
+	loads := make([]float64, n)
+	for i, bucket := range assignments {
+		loads[bucket] += weights[i]
+	}
+	max := 0.0
+	for _, load := range loads {
+		if load > max {
+			max = load
+		}
+	}
+	return max
+}
+
+func TestAssignLPT(t *testing.T) {
+	// A skewed workload: a few hosts query long time ranges, most query an hour.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	var queries []device.Query
+	var weights []float64
+	for i := 0; i < 100; i++ {
+		length := time.Hour
+		if i%10 == 0 {
+			length = time.Duration(24*(i/10+1)) * time.Hour
+		}
+		queries = append(queries, device.MinMaxCPUQuery{
+			Hostname:  fmt.Sprintf("host_%06d", i%20),
+			StartTime: start,
+			EndTime:   start.Add(length),
+		})
+		weights = append(weights, length.Minutes())
+	}
+
+	const n = 4
+	lpt, err := AssignLPT(queries, n, TimeRangeWeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostID, err := Assign(queries, n, NewQueryHostnameBalancer(HostIDBalancer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	random, err := Assign(queries, n, RandomBalancer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	lptMax := maxLoad(lpt, weights, n)
+	t.Logf("max load: lpt %.0f, host-id %.0f, random %.0f, lower bound %.0f",
+		lptMax, maxLoad(hostID, weights, n), maxLoad(random, weights, n), total/n)
+
+	// LPT is within 4/3 of the optimal makespan, which is at least the mean load and the heaviest query.
+	if bound := math.Max(total/n, 240*60); lptMax > bound*4/3 {
+		t.Errorf("expected the LPT max load %.0f to be within 4/3 of %.0f", lptMax, bound)
+	}
+	if lptMax > maxLoad(hostID, weights, n) {
+		t.Errorf("expected LPT to balance better than host-id")
+	}
+}
+
+func TestLeastLoadedBalancer(t *testing.T) {
+	balance := NewLeastLoadedBalancer()
+	for i, weight := range []float64{5, 3, 2, 2} {
+		bucket, err := balance(weight, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 5 -> 0, 3 -> 1, 2 -> 1 (load 3 < 5), 2 -> 0 (load 5 = 5, first bucket wins).
+		if expect := []int{0, 1, 1, 0}[i]; bucket != expect {
+			t.Errorf("expected weight #%d to be assigned to bucket %d but got %d", i, expect, bucket)
+		}
+	}
+	if _, err := balance("host_000001", 2); err == nil {
+		t.Error("expected an error for a non-float64 value")
+	}
+}
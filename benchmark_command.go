@@ -3,243 +3,789 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/sbward/ts-query-workers/device"
-	"github.com/sbward/ts-query-workers/stats"
 )
 
 // BenchmarkCommand reads a CSV file of query specifications and executes the queries across a concurrent worker pool.
-// After execution completes, a report is printed to stdout with execution statistics.
-// All configuration options are required.
+// After execution completes, a report with execution statistics is written to the Output.
+// Configuration options are required unless documented as optional.
 type BenchmarkCommand struct {
-	// CSV is the source file of query specifications to benchmark.
+	// CSV is the source file of query specifications to benchmark, in the InputFormat.
 	CSV *os.File
 
+	// Stream reads the query specifications while they are executed, so inputs of any size can be benchmarked.
+	// It cannot be combined with a Duration, Iterations, a warm-up, the "lpt" Balancer or the "steal" Scheduler.
+	Stream bool
+
+	// InputFormat is the format of the query specifications: "csv" or "jsonl".
+	// Optional, defaults to "jsonl" if the CSV file has a .jsonl or .ndjson extension, and "csv" otherwise.
+	InputFormat string
+
+	// CSVOptions configure how times are read from a CSV file, and whether rows that can't be read are skipped.
+	// Optional, defaults to failing on the first row that can't be read.
+	CSVOptions CSVOptions
+
+	// Validation configures the checks of each query, which stop the benchmark on an error unless SkipValidation is set.
+	// Optional, defaults to the checks of the zero Validation.
+	Validation Validation
+
+	// SkipValidation starts the benchmark even if the validation found errors.
+	SkipValidation bool
+
 	// DB is the SQL database to execute queries against.
 	DB *sql.DB
 
 	// Concurrency is the number of workers to start.
 	Concurrency int
 
-	workers *sync.WaitGroup
+	// Template builds the query for each query specification. Optional, defaults to device.MinMaxCPUTemplate.
+	Template device.Template
+
+	// Mode is how each query is measured: "explain" for the server-side time reported by EXPLAIN ANALYZE,
+	// "exec" for the round trip of executing it from the client, or "both". Optional, defaults to "explain".
+	Mode string
+
+	// ExactPercentiles keeps every measurement in memory to compute exact percentiles,
+	// instead of estimating them with bounded memory. Only suitable for small runs.
+	ExactPercentiles bool
+
+	// Reporter writes the report after execution completes. Optional, defaults to TextReporter.
+	Reporter Reporter
+
+	// Output is the destination of the report. Optional, defaults to stdout.
+	// If Output is an io.Closer other than stdout, it is closed after the report is written.
+	Output io.Writer
+
+	// Log is the destination of progress messages and per-query results. Optional, defaults to stdout.
+	// If Log is a terminal, the progress of each phase is displayed in place.
+	Log io.Writer
+
+	// Quiet skips per-query results in the Log, leaving only the progress display.
+	Quiet bool
+
+	// QueryLog is the destination of per-query results instead of the Log. Optional.
+	// If QueryLog is an io.Closer, it is closed when Exec returns.
+	QueryLog io.Writer
+
+	// Duration keeps replaying the query set until it elapses. Optional, if zero the query set is executed once.
+	Duration time.Duration
+
+	// Rate is the target rate of query arrivals per second for an open-loop benchmark, where queries
+	// are started on schedule regardless of whether previous queries have completed.
+	// Optional, if zero each worker executes its queries back to back (closed loop).
+	Rate float64
+
+	// Arrival is the arrival process of an open-loop benchmark: "poisson" or "constant".
+	// Optional, defaults to "poisson".
+	Arrival string
+
+	// Iterations is the number of times to run the whole workload. Optional, defaults to 1.
+	Iterations int
+
+	// WarmupQueries is the number of queries to execute before the benchmark, which are excluded from statistics.
+	// Optional, cannot be combined with WarmupDuration.
+	WarmupQueries int
+
+	// WarmupDuration is how long to execute queries before the benchmark, which are excluded from statistics.
+	// Optional, cannot be combined with WarmupQueries.
+	WarmupDuration time.Duration
+
+	// MaxErrors aborts the benchmark once more queries than this have failed, excluding the warm-up.
+	// Optional, if zero the benchmark never aborts on errors.
+	MaxErrors int
+
+	// QueryTimeout cancels a query attempt that runs for longer. Optional, if zero queries may run indefinitely.
+	QueryTimeout time.Duration
+
+	// Balancer is the name of the Balancer that assigns the host of each query to a worker,
+	// e.g. "hash", "ring" or "rendezvous", or "lpt" to assign queries to workers by their Weight.
+	// Optional, defaults to "hash".
+	Balancer string
+
+	// Weight estimates the time to execute each query for the "lpt" Balancer: "range" for the length of
+	// its time range, or "cost" for the cost estimated by an EXPLAIN of each query before the benchmark.
+	// Optional, defaults to "range".
+	Weight string
+
+	// Scheduler is the strategy for distributing queries to workers: "hash", "shared" or "steal".
+	// Optional, defaults to "hash".
+	Scheduler string
+
+	// Retry executes queries that failed with a transient error again. Optional, if zero queries are not retried.
+	Retry RetryPolicy
+
+	// MetricsAddr is the address to serve live metrics on in the Prometheus text exposition format, e.g. ":9100".
+	// The metrics exclude the warm-up. Optional, if empty metrics are not served.
+	MetricsAddr string
+
+	workers  *sync.WaitGroup
+	arrivals Arrivals
+	metrics  *LiveMetrics
 }
 
 func (c *BenchmarkCommand) Exec(ctx context.Context) error {
+	if closer, ok := c.QueryLog.(io.Closer); ok {
+		defer closer.Close()
+	}
+
 	// Validate concurrency.
 
 	if c.Concurrency <= 0 {
 		return fmt.Errorf("concurrency must be greater than zero (received: %d)", c.Concurrency)
 	}
 
-	queries, err := queriesFromCSV(
-		csv.NewReader(c.CSV),
-		func(q *device.MinMaxCPUQuery) { q.BucketSize = "1m" },
-	)
-	c.CSV.Close()
+	if c.WarmupQueries > 0 && c.WarmupDuration > 0 {
+		return fmt.Errorf("a warm-up can be limited by a number of queries or a duration, but not both")
+	}
+
+	if c.Scheduler == "" {
+		c.Scheduler = SchedulerHash
+	}
+	if err := validateScheduler(c.Scheduler); err != nil {
+		return err
+	}
+
+	if c.Mode == "" {
+		c.Mode = ModeExplain
+	}
+	if err := validateMode(c.Mode); err != nil {
+		return err
+	}
+
+	tmpl := c.Template
+	if tmpl == nil {
+		tmpl = device.MinMaxCPUTemplate
+	}
+
+	format, err := inputFormat(c.InputFormat, c.CSV.Name())
 	if err != nil {
+		c.CSV.Close()
 		return err
 	}
 
-	// Assign queries to workers by hashing the query hostname.
+	var base workload
+	var skipped *SkippedReport
+	var validated *validatedQueries
+
+	if c.Stream {
+		// Read queries while they are executed, and assign each one to a bucket as it is read.
+
+		defer c.CSV.Close()
+		if err := c.validateStream(); err != nil {
+			return err
+		}
+		balancer, err := NewBalancer(c.balancer())
+		if err != nil {
+			return fmt.Errorf("failed to assign queries to buckets: %w", err)
+		}
+		validated = &validatedQueries{
+			reader:     newQueryReader(c.CSV, format, c.CSVOptions, tmpl, defaultBucketSize("1m")),
+			validation: c.Validation,
+			lenient:    c.CSVOptions.Lenient,
+			keep:       c.SkipValidation,
+		}
+		base.stream = &queryStream{reader: validated, balancer: balancer}
+	} else {
+		reader := newQueryReader(c.CSV, format, c.CSVOptions, tmpl, defaultBucketSize("1m"))
+		queries, err := readQueries(reader)
+		c.CSV.Close()
+		if err != nil {
+			return err
+		}
+		skipped = newSkippedReport(reader.skipped())
+		c.logSkipped(skipped)
+
+		if err := c.validate(queries); err != nil {
+			return err
+		}
+
+		// Assign each query to one of N buckets, where N is the concurrency.
 
-	balancer := NewQueryHostnameBalancer(NewHashBalancer(fnv.New32()))
+		assignments, err := c.assign(ctx, queries)
+		if err != nil {
+			return fmt.Errorf("failed to assign queries to buckets: %w", err)
+		}
+		base = workload{queries: queries, assignments: assignments, duration: c.Duration}
+	}
 
-	// Group queries into N buckets, where N is the concurrency.
+	if c.Rate > 0 {
+		arrival := c.Arrival
+		if arrival == "" {
+			arrival = "poisson"
+		}
+		if c.arrivals, err = NewArrivals(arrival, c.Rate); err != nil {
+			return err
+		}
+	}
 
-	buckets, err := Buckets(queries, *concurrency, balancer)
-	if err != nil {
-		return fmt.Errorf("failed to assign queries to buckets: %w", err)
+	if c.MetricsAddr != "" {
+		stop, err := c.serveMetrics()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	report := &Report{Scheduler: c.Scheduler}
+
+	var progress *progress
+
+	// Warm up caches and connections with queries that are excluded from statistics.
+
+	if warmup := c.warmupWorkload(base.queries, base.assignments); warmup != nil {
+		fmt.Fprintln(c.log(), "Warming up...")
+
+		report.Warmup = &WarmupReport{}
+
+		progress = newProgress(c.log(), warmup.size())
+		logResult := c.logResult(progress)
+
+		start := time.Now()
+		for result := range c.run(ctx, *warmup) {
+			logResult(result)
+			report.Warmup.Queries++
+			if result.Error != nil {
+				report.Warmup.Errors++
+			}
+		}
+		report.Warmup.Duration = time.Since(start)
+		progress.done()
+	}
+
+	iterations := c.Iterations
+	if iterations <= 0 {
+		iterations = 1
+	}
+
+	report.Stats = newBenchmarkStats(c.Concurrency, c.ExactPercentiles)
+	report.Stats.Mode = c.Mode
+
+	// Abort the benchmark by cancelling its context when too many queries have failed.
+
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	tooManyErrors := false
+	checkErrors := func(*QueryExecutionResult) {
+		if c.MaxErrors > 0 && report.Stats.Errors.Global.Total() > c.MaxErrors && !tooManyErrors {
+			tooManyErrors = true
+			progress.println(fmt.Sprintf("Aborting after %d errors...", report.Stats.Errors.Global.Total()))
+			abort()
+		}
+	}
+
+	// Keep the result of every query only for a Reporter that writes them. Once a result has been aggregated,
+	// its plan tree is replaced by a summary.
+
+	reportQueries := reportsQueries(c.Reporter)
+	collect := func(result *QueryExecutionResult) {
+		if !reportQueries {
+			return
+		}
+		if result.Stats != nil {
+			result.Plan = result.planSummary()
+			result.Stats.Plan = nil
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	for i := 1; i <= iterations && ctx.Err() == nil; i++ {
+		if iterations > 1 {
+			fmt.Fprintf(c.log(), "Iteration %d of %d:\n", i, iterations)
+		}
+		queries := fmt.Sprintf("%d queries", len(base.queries))
+		if base.stream != nil {
+			queries = "streamed queries"
+		}
+		if c.Rate > 0 {
+			fmt.Fprintf(c.log(), "Benchmarking %s at %g queries/s across %d workers...\n", queries, c.Rate, c.Concurrency)
+		} else {
+			fmt.Fprintf(c.log(), "Benchmarking %s across %d workers...\n", queries, c.Concurrency)
+		}
+
+		w := base
+		progress = newProgress(c.log(), w.size())
+
+		start := time.Now()
+		results := c.run(ctx, w)
+
+		// Aggregate stats received on the results channel, for the iteration and for the whole report.
+
+		iteration := i
+		stats := aggregateStats(c.Concurrency, c.ExactPercentiles, results,
+			func(result *QueryExecutionResult) { result.Iteration = iteration },
+			c.logResult(progress),
+			report.Stats.Push,
+			checkErrors,
+			collect,
+		)
+
+		// The results channel is closed once every worker has finished, which ends the makespan.
+		stats.Makespan = time.Since(start)
+		stats.Mode = c.Mode
+		progress.done()
+		report.Stats.Makespan += stats.Makespan
+		report.Iterations = append(report.Iterations, stats)
+	}
+
+	// The issues and rows skipped by a stream are only known once it has stopped reading, which may be after the
+	// workers if the benchmark was cancelled.
+
+	if base.stream != nil {
+		base.stream.wait()
+		if validated.errors+validated.warnings > 0 {
+			writeIssues(c.log(), validated.read, validated.errors, validated.warnings, validated.issues, maxLoggedIssues)
+		}
+		skipped = newSkippedReport(base.stream.reader.skipped())
+		c.logSkipped(skipped)
+	}
+	report.Skipped = skipped
+
+	// If the benchmark was cancelled or aborted, report the results gathered so far.
+
+	report.Partial = ctx.Err() != nil || (base.stream != nil && base.stream.err != nil)
+
+	if err := c.writeReport(report); err != nil {
+		return err
+	}
+
+	if base.stream != nil && base.stream.err != nil {
+		return fmt.Errorf("benchmark stopped reading queries, the report is partial: %w", base.stream.err)
+	}
+
+	if tooManyErrors {
+		return fmt.Errorf("benchmark was aborted after %d errors exceeded the maximum of %d, the report is partial",
+			report.Stats.Errors.Global.Total(), c.MaxErrors)
+	}
+
+	if report.Partial {
+		return fmt.Errorf("benchmark was interrupted, the report is partial: %w", ctx.Err())
+	}
+
+	return nil
+}
+
+// LogSkipped logs the rows of the input that were skipped because they couldn't be read.
+func (c *BenchmarkCommand) logSkipped(skipped *SkippedReport) {
+	if skipped == nil {
+		return
+	}
+	fmt.Fprintf(c.log(), "Skipped %d rows that could not be read:\n", skipped.Rows)
+	for _, err := range skipped.Errors {
+		fmt.Fprintf(c.log(), "  %s\n", err)
+	}
+	if more := skipped.Rows - len(skipped.Errors); more > 0 {
+		fmt.Fprintf(c.log(), "  ...and %d more\n", more)
+	}
+}
+
+// Validate checks the queries before they are executed and logs the issues found.
+// It returns an error if any issue is an error, unless SkipValidation is set.
+func (c *BenchmarkCommand) validate(queries []device.Query) error {
+	issues := c.Validation.Validate(queries)
+	if len(issues) == 0 {
+		return nil
+	}
+	errs, warnings := countIssues(issues)
+	writeIssues(c.log(), len(queries), errs, warnings, issues, maxLoggedIssues)
+	if errs > 0 && !c.SkipValidation {
+		return fmt.Errorf("validation failed with %d errors, so the benchmark wasn't started", errs)
+	}
+	return nil
+}
+
+// Assign returns the bucket of each query with the Balancer.
+func (c *BenchmarkCommand) assign(ctx context.Context, queries []device.Query) ([]int, error) {
+	var weight QueryWeight = TimeRangeWeight
+	switch c.Weight {
+	case "", "range":
+	case "cost":
+		weight = NewCostWeight(ctx, c.DB)
+	default:
+		return nil, fmt.Errorf("unknown query weight %q (expected range or cost)", c.Weight)
+	}
+
+	return assignQueries(queries, c.Concurrency, c.balancer(), withSpecWeight(weight))
+}
+
+// Balancer returns the name of the Balancer, which defaults to "hash".
+func (c *BenchmarkCommand) balancer() string {
+	if c.Balancer == "" {
+		return "hash"
 	}
+	return c.Balancer
+}
 
-	fmt.Printf("Benchmarking %d queries across %d workers...\n", len(queries), *concurrency)
+// Workload is a set of queries assigned to workers, which is replayed for a duration if it is set,
+// or a stream of queries which are assigned to workers as they are read.
+type workload struct {
+	queries     []device.Query
+	assignments []int
+	duration    time.Duration
+	stream      *queryStream
 
-	// Launch one worker per bucket. Fan-in results to a single channel.
+	// Warmup is set for the warm-up phase, whose queries are not observed by the live metrics.
+	warmup bool
+}
+
+// Size returns the number of queries of the workload, or 0 if it is replayed for a duration or streamed.
+func (w workload) size() int {
+	if w.duration > 0 || w.stream != nil {
+		return 0
+	}
+	return len(w.queries)
+}
+
+// WarmupWorkload returns the workload of the warm-up phase, or nil if there is no warm-up.
+// A number of warm-up queries is taken from the start of the query set, repeating it if necessary.
+func (c *BenchmarkCommand) warmupWorkload(queries []device.Query, assignments []int) *workload {
+	if c.WarmupDuration > 0 {
+		return &workload{queries: queries, assignments: assignments, duration: c.WarmupDuration, warmup: true}
+	}
+	if c.WarmupQueries <= 0 || len(queries) == 0 {
+		return nil
+	}
+	w := &workload{
+		queries:     make([]device.Query, c.WarmupQueries),
+		assignments: make([]int, c.WarmupQueries),
+		warmup:      true,
+	}
+	for i := range w.queries {
+		w.queries[i] = queries[i%len(queries)]
+		w.assignments[i] = assignments[i%len(queries)]
+	}
+	return w
+}
+
+// Run executes a workload across the worker pool and returns a channel of results, which is closed when done.
+func (c *BenchmarkCommand) run(ctx context.Context, w workload) <-chan *QueryExecutionResult {
+	var deadline time.Time
+	if w.duration > 0 {
+		deadline = time.Now().Add(w.duration)
+	}
+
+	// Schedule jobs for the workers, then launch the workers. Fan-in results to a single channel.
+
+	done := make(chan struct{})
+	sources := c.schedule(ctx, w, deadline, done)
 
 	results := make(chan *QueryExecutionResult)
 
+	metrics := c.metrics
+	if w.warmup {
+		metrics = nil
+	}
+
 	c.workers = &sync.WaitGroup{}
 
-	for bucket, queries := range buckets {
+	for worker, next := range sources {
 		c.workers.Add(1)
-		go c.queryWorker(ctx, bucket, queries, results)
+		go c.queryWorker(ctx, worker, next, metrics, results)
 	}
 
 	// Wait for all workers to complete, then close the results channel.
 
 	go func() {
 		c.workers.Wait()
+		close(done)
 		close(results)
 	}()
 
-	// Aggregate stats received on the results channel then print a report.
-
-	stats := aggregateStats(*concurrency, results)
+	return results
+}
 
-	fmt.Println()
+// ServeMetrics starts serving live metrics at /metrics on the MetricsAddr, and returns a function that stops the server.
+func (c *BenchmarkCommand) serveMetrics() (stop func(), err error) {
+	listener, err := net.Listen("tcp", c.MetricsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve metrics: %w", err)
+	}
 
-	fmt.Println("Execution time:")
-	fmt.Println()
-	fmt.Println(stats.ExecutionTimeTable())
+	c.metrics = NewLiveMetrics()
 
-	fmt.Println()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metrics)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
 
-	fmt.Println("Execution cost:")
-	fmt.Println()
-	fmt.Println(stats.CostTable())
+	fmt.Fprintf(c.log(), "Serving metrics on http://%s/metrics\n", listener.Addr())
 
-	return nil
+	return func() { server.Close() }, nil
 }
 
-// QueryWorker executes a series of queries and sends the results to a result channel.
-func (b *BenchmarkCommand) queryWorker(ctx context.Context, bucket int, queries []*device.MinMaxCPUQuery, results chan<- *QueryExecutionResult) {
-	defer b.workers.Done()
+// WriteReport writes the report to the Output with the Reporter, then closes the Output.
+func (c *BenchmarkCommand) writeReport(report *Report) error {
+	reporter := c.Reporter
+	if reporter == nil {
+		reporter = TextReporter{}
+	}
 
-	for _, query := range queries {
-		stats, err := query.ExplainAnalyze(ctx, b.DB)
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	err := reporter.Report(out, report)
 
-		result := &QueryExecutionResult{
-			Query:  query,
-			Stats:  stats,
-			Error:  err,
-			Worker: bucket,
+	if closer, ok := out.(io.Closer); ok && out != os.Stdout {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
 		}
+	}
 
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
 
-		select {
-		case <-ctx.Done():
-			return
-		case results <- result:
+	return nil
+}
+
+// LogResult returns a function that writes a query result to the QueryLog, or to the Log above the progress display
+// unless Quiet, then counts it in the progress display.
+func (c *BenchmarkCommand) logResult(p *progress) func(*QueryExecutionResult) {
+	return func(result *QueryExecutionResult) {
+		switch {
+		case c.QueryLog != nil:
+			fmt.Fprintln(c.QueryLog, result)
+		case !c.Quiet:
+			p.println(result)
 		}
+		p.observe(result)
 	}
 }
 
-// QueryExecutionResult is a report of the result of executing a MinMaxCPUQuery by a Worker.
-type QueryExecutionResult struct {
-	Query   *device.MinMaxCPUQuery
-	Results []device.MinMaxBucket
-	Stats   *device.QueryStats
-	Error   error
-	Worker  int
+func (c *BenchmarkCommand) log() io.Writer {
+	if c.Log == nil {
+		return os.Stdout
+	}
+	return c.Log
 }
 
-func (q *QueryExecutionResult) String() string {
-	start := q.Query.StartTime.Format(csvTimeFormat)
-	end := q.Query.EndTime.Format(csvTimeFormat)
-	if q.Error != nil {
-		return fmt.Sprintf("❌ %s, %s, %s: %s", q.Query.Hostname, start, end, q.Error)
-	}
-	return fmt.Sprintf("✅ %s, %s, %s -> %s, worker %d", q.Query.Hostname, start, end, q.Stats.ExecutionTime.Round(time.Microsecond), q.Worker)
+// OpenLoopQueueSize is the capacity of each worker's job queue in an open-loop benchmark.
+const openLoopQueueSize = 4096
+
+// QueryJob is a query scheduled for execution by a worker.
+type queryJob struct {
+	Query device.Query
+
+	// Scheduled is the time the query should have started, from which its latency is measured.
+	// If zero, the query is scheduled when a worker receives it.
+	Scheduled time.Time
 }
 
-type BenchmarkStats struct {
-	ExecTimeGlobal   *stats.Aggregator[time.Duration]
-	ExecTimeByWorker []*stats.Aggregator[time.Duration]
-	CostGlobal       *stats.Aggregator[float32]
-	CostByWorker     []*stats.Aggregator[float32]
+// ScheduleClosedLoop sends the queries of one worker in order without a schedule,
+// so each query starts as soon as the worker has finished the previous one.
+// If the deadline is set, the queries are repeated until it passes. The jobs channel is closed when done.
+func scheduleClosedLoop(ctx context.Context, queries []device.Query, deadline time.Time, jobs chan<- *queryJob) {
+	defer close(jobs)
+
+	for len(queries) > 0 {
+		for _, query := range queries {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- &queryJob{Query: query}:
+			}
+		}
+		if deadline.IsZero() {
+			return
+		}
+	}
 }
 
-// AggregateStats aggregates query statistics from the results channel until it closes, then returns the final BenchmarkStats.
-func aggregateStats(numWorkers int, results <-chan *QueryExecutionResult) BenchmarkStats {
-	// Collects stats for all queries across all workers.
-	execTimeGlobal := stats.NewAggregator[time.Duration]()
-	costGlobal := stats.NewAggregator[float32]()
+// ScheduleOpenLoop replays the queries in order, sending each one to the queue of its assigned worker
+// at the time of its arrival. If the deadline is set, the queries are repeated until it passes,
+// otherwise they are sent once. The queues are closed when done.
+func scheduleOpenLoop(ctx context.Context, queries []device.Query, assignments []int, arrivals Arrivals, deadline time.Time, queues jobQueues) {
+	defer queues.close()
 
-	// Collects stats for each worker.
-	execTimeWorkers := make([]*stats.Aggregator[time.Duration], numWorkers)
-	costWorkers := make([]*stats.Aggregator[float32], numWorkers)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
 
-	for result := range results {
-		fmt.Println(result)
+	next := time.Now()
 
-		execTimeGlobal.Push(result.Stats.ExecutionTime)
-		costGlobal.Push(result.Stats.Cost)
+	for i := 0; len(queries) > 0; i++ {
+		if i == len(queries) && deadline.IsZero() {
+			return
+		}
 
-		// Execution time per worker
+		// Arrivals are scheduled in absolute time, so a delayed send doesn't delay subsequent arrivals.
+		next = next.Add(arrivals())
+		if !deadline.IsZero() && next.After(deadline) {
+			return
+		}
 
-		workerTime := execTimeWorkers[result.Worker]
-		if workerTime == nil {
-			workerTime = stats.NewAggregator[time.Duration]()
-			execTimeWorkers[result.Worker] = workerTime
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
 		}
-		workerTime.Push(result.Stats.ExecutionTime)
 
-		// Cost per worker
+		j := i % len(queries)
 
-		workerCost := costWorkers[result.Worker]
-		if workerCost == nil {
-			workerCost = stats.NewAggregator[float32]()
-			costWorkers[result.Worker] = workerCost
+		if !queues.send(ctx, assignments[j], &queryJob{Query: queries[j], Scheduled: next}) {
+			return
 		}
-		workerCost.Push(result.Stats.Cost)
 	}
-
-	return BenchmarkStats{execTimeGlobal, execTimeWorkers, costGlobal, costWorkers}
 }
 
-// TableString returns a human-readable table of the benchmark results.
-func (b BenchmarkStats) ExecutionTimeTable() string {
-	table := "| Worker | Queries | Total | Minimum | Maximum | Average |  Median |\n"
-	table += "|--------|---------|-------|---------|---------|---------|---------|\n"
-	table += b.tableLineExecTime("ALL", b.ExecTimeGlobal)
+// QueryWorker executes the jobs it takes from its source and sends the results to a result channel.
+// When the context is cancelled, the worker stops after its in-flight query.
+func (b *BenchmarkCommand) queryWorker(ctx context.Context, worker int, next nextJob, metrics *LiveMetrics, results chan<- *QueryExecutionResult) {
+	defer b.workers.Done()
 
-	for worker, wstats := range b.ExecTimeByWorker {
-		if wstats == nil {
-			wstats = &stats.Aggregator[time.Duration]{}
+	for job, ok := next(); ok; job, ok = next() {
+		// Stop taking jobs once the benchmark is cancelled.
+		if ctx.Err() != nil {
+			return
 		}
-		table += b.tableLineExecTime(fmt.Sprint(worker), wstats)
-	}
 
-	return table
+		scheduled := job.Scheduled
+		if scheduled.IsZero() {
+			scheduled = time.Now()
+		}
+
+		result := &QueryExecutionResult{
+			Query:     job.Query,
+			Worker:    worker,
+			Scheduled: scheduled,
+			Mode:      b.Mode,
+		}
+		metrics.Start(worker, job.Query.Template())
+
+		start := time.Now()
+		b.execute(ctx, result)
+		result.Elapsed = time.Since(start)
+		result.Latency = time.Since(scheduled)
+
+		// A query aborted by the cancellation has no result worth reporting.
+		if result.Error != nil && ctx.Err() != nil {
+			metrics.Cancel(worker, job.Query.Template())
+			return
+		}
+
+		metrics.Finish(result)
+
+		// Results are received until every worker is done, so results of in-flight queries
+		// are still delivered after the benchmark is cancelled.
+		results <- result
+	}
 }
 
-func (b BenchmarkStats) CostTable() string {
-	table := "| Worker | Queries | Total | Minimum | Maximum | Average |  Median |\n"
-	table += "|--------|---------|-------|---------|---------|---------|---------|\n"
-	table += b.tableLineCost("ALL", b.CostGlobal)
+// Execute executes the query of a result, retrying it according to the Retry policy,
+// and records the stats or error of the last attempt.
+func (b *BenchmarkCommand) execute(ctx context.Context, result *QueryExecutionResult) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
 
-	for worker, wstats := range b.CostByWorker {
-		if wstats == nil {
-			wstats = &stats.Aggregator[float32]{}
+	for {
+		result.Attempts++
+		result.Stats, result.TimedOut, result.Error = b.attempt(ctx, result.Query)
+
+		if result.Error == nil || ctx.Err() != nil || !b.Retry.retry(result.Attempts, result.Error) {
+			return
+		}
+
+		timer.Reset(b.Retry.delay(result.Attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
 		}
-		table += b.tableLineCost(fmt.Sprint(worker), wstats)
 	}
+}
+
+// Attempt executes a query once within the QueryTimeout, and reports whether the attempt timed out.
+func (b *BenchmarkCommand) attempt(ctx context.Context, query device.Query) (stats *device.QueryStats, timedOut bool, err error) {
+	if b.QueryTimeout <= 0 {
+		stats, err = measure(ctx, b.Mode, b.DB, query)
+		return stats, false, err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	stats, err = measure(queryCtx, b.Mode, b.DB, query)
+
+	// The driver reports a cancelled statement rather than the deadline, so the timeout is checked on the context.
+	if err != nil && ctx.Err() == nil && errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+		return nil, true, fmt.Errorf("%w: query timed out after %s: %v", context.DeadlineExceeded, b.QueryTimeout, err)
+	}
+
+	return stats, false, err
+}
+
+// QueryExecutionResult is a report of the result of executing a device.Query by a Worker.
+type QueryExecutionResult struct {
+	Query   device.Query
+	Results []device.MinMaxBucket
+	Stats   *device.QueryStats
+	Error   error
+	Worker  int
+
+	// Scheduled is the time the query should have started.
+	Scheduled time.Time
+
+	// Latency is the time from Scheduled until the query completed, including time spent waiting for the worker.
+	Latency time.Duration
+
+	// Elapsed is the time the worker spent executing the query, including retries.
+	Elapsed time.Duration
+
+	// Iteration is the number of the iteration the query was executed in, starting from 1.
+	Iteration int
+
+	// Attempts is the number of times the query was executed, which is more than 1 if it was retried.
+	Attempts int
+
+	// TimedOut is true if the last attempt was cancelled by the query timeout.
+	TimedOut bool
 
-	return table
+	// Mode is how the query was measured. If empty, it was measured with ModeExplain.
+	Mode string
+
+	// Plan summarizes the plan of the query once Stats.Plan has been aggregated and dropped,
+	// so a collected result doesn't hold on to its plan tree.
+	Plan *device.PlanSummary
 }
 
-func (b BenchmarkStats) tableLineExecTime(worker string, agg *stats.Aggregator[time.Duration]) string {
-	return fmt.Sprintf(
-		"| %6s | %7d | %5s | %7s | %7s | %7s | %7s |\n",
-		worker,
-		agg.Count,
-		agg.Total.Round(time.Millisecond),
-		agg.Min.Round(time.Microsecond),
-		agg.Max.Round(time.Microsecond),
-		time.Duration(agg.Avg).Round(time.Microsecond),
-		time.Duration(agg.Med).Round(time.Microsecond),
-	)
+// PlanSummary returns the summary of the plan of the query, or nil if the query has no plan.
+func (q *QueryExecutionResult) planSummary() *device.PlanSummary {
+	if q.Plan == nil && q.Stats != nil && q.Stats.Plan != nil {
+		return q.Stats.Plan.Summary()
+	}
+	return q.Plan
 }
 
-func (b BenchmarkStats) tableLineCost(worker string, agg *stats.Aggregator[float32]) string {
-	return fmt.Sprintf(
-		"| %6s | %7d | %5d | %7d | %7d | %7d | %7d |\n",
-		worker,
-		agg.Count,
-		int(agg.Total),
-		int(agg.Min),
-		int(agg.Max),
-		int(agg.Avg),
-		int(agg.Med),
-	)
+func (q *QueryExecutionResult) String() string {
+	startTime, endTime := q.Query.TimeRange()
+	start := startTime.Format(csvTimeFormat)
+	end := endTime.Format(csvTimeFormat)
+	var attempts string
+	if q.Attempts > 1 {
+		attempts = fmt.Sprintf(" after %d attempts", q.Attempts)
+	}
+	if q.Error != nil {
+		return fmt.Sprintf("❌ %s, %s, %s: %s%s", q.Query.Host(), start, end, q.Error, attempts)
+	}
+	measured := q.Stats.ExecutionTime
+	if !explained(q.Mode) {
+		measured = q.Stats.RoundTrip
+	}
+	return fmt.Sprintf("✅ %s, %s, %s -> %s, worker %d%s", q.Query.Host(), start, end, measured.Round(time.Microsecond), q.Worker, attempts)
 }
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+const testPlanJSON = `[{"Plan": {"Node Type": "Sort", "Total Cost": 100, "Actual Total Time": 2.5}}]`
+
+// TestCSVFile writes CSV data to a temporary file and opens it for reading.
+func testCSVFile(t *testing.T, data string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queries.csv")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// ExpectExplain expects n EXPLAIN ANALYZE queries in any order, each returning testPlanJSON.
+func expectExplain(mock sqlmock.Sqlmock, n int) {
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < n; i++ {
+		mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+	}
+}
+
+// RunJSONBenchmark executes the command against the CSV data and returns its decoded JSON report.
+func runJSONBenchmark(t *testing.T, cmd *BenchmarkCommand, csvData string) jsonReport {
+	t.Helper()
+
+	var out bytes.Buffer
+	cmd.CSV = testCSVFile(t, csvData)
+	cmd.Reporter = JSONReporter{}
+	cmd.Output = &out
+	cmd.Log = io.Discard
+
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal("benchmark failed:", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal("failed to decode report:", err)
+	}
+	return report
+}
+
+const testCSVData = `hostname,start_time,end_time
+host_000001,2017-01-01 08:59:22,2017-01-01 09:59:22
+host_000002,2017-01-02 13:02:02,2017-01-02 14:02:02
+host_000003,2017-01-02 18:50:28,2017-01-02 19:50:28
+`
+
+func TestBenchmarkCommandExec(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3)
+
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2}, testCSVData)
+
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 3 {
+		t.Errorf("expected 3 queries in stats but got %d", n)
+	}
+	if avg := report.Stats["execution_time_ms"].Global.Avg; avg != 2.5 {
+		t.Errorf("expected average execution time 2.5ms but got %f", avg)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// ExpectSelect expects n queries executed without EXPLAIN in any order, each returning two rows of 17 bytes.
+func expectSelect(mock sqlmock.Sqlmock, n int) {
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < n; i++ {
+		mock.ExpectQuery("^SELECT").WillReturnRows(
+			sqlmock.NewRows([]string{"time", "min_usage", "max_usage"}).
+				AddRow("2017-01-01", "0.25", "0.5").
+				AddRow("2017-01-02", "0.75", "1.0"),
+		)
+	}
+}
+
+func TestBenchmarkCommandModes(t *testing.T) {
+	for _, mode := range Modes {
+		t.Run(mode, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("failed to init sqlmock:", err)
+			}
+			if explained(mode) {
+				expectExplain(mock, 3)
+			}
+			if executed(mode) {
+				expectSelect(mock, 3)
+			}
+
+			report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Mode: mode}, testCSVData)
+
+			if report.Mode != mode {
+				t.Errorf("expected mode %s to be reported but got %q", mode, report.Mode)
+			}
+			if _, ok := report.Stats["execution_time_ms"]; ok != explained(mode) {
+				t.Errorf("expected execution time to be reported: %t", explained(mode))
+			}
+			if _, ok := report.Stats["overhead_ms"]; ok != (mode == ModeBoth) {
+				t.Errorf("expected overhead to be reported: %t", mode == ModeBoth)
+			}
+			if executed(mode) {
+				if rows := report.Stats["rows"].Global; rows.Count != 3 || rows.Total != 6 {
+					t.Errorf("expected 3 queries of 2 rows but got %d queries of %f rows", rows.Count, rows.Total)
+				}
+				if bytes := report.Stats["bytes"].Global.Total; bytes != 3*2*17 {
+					t.Errorf("expected 102 bytes but got %f", bytes)
+				}
+				if q := report.Queries[0]; q.RoundTripMillis <= 0 || q.Rows != 2 {
+					t.Errorf("expected the round trip and rows of each query but got %+v", q)
+				}
+			}
+			if mode == ModeBoth {
+				q := report.Queries[0]
+				if overhead := math.Max(q.RoundTripMillis-q.ExecutionTimeMillis-q.PlanningTimeMillis, 0); math.Abs(q.OverheadMillis-overhead) > 1e-6 {
+					t.Errorf("expected overhead %f but got %f", overhead, q.OverheadMillis)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestBenchmarkCommandWarmup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 5+3)
+
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, WarmupQueries: 5}, testCSVData)
+
+	if report.Warmup == nil || report.Warmup.Queries != 5 {
+		t.Fatalf("expected 5 warm-up queries but got %+v", report.Warmup)
+	}
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 3 {
+		t.Errorf("expected warm-up queries to be excluded from stats but got %d queries", n)
+	}
+	if n := len(report.Queries); n != 3 {
+		t.Errorf("expected warm-up queries to be excluded from query results but got %d results", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBenchmarkCommandIterations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3*3)
+
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Iterations: 3}, testCSVData)
+
+	if n := len(report.Iterations); n != 3 {
+		t.Fatalf("expected stats for 3 iterations but got %d", n)
+	}
+	if n := report.Iterations[2]["execution_time_ms"].Global.Count; n != 3 {
+		t.Errorf("expected 3 queries in the last iteration but got %d", n)
+	}
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 9 {
+		t.Errorf("expected 9 queries across all iterations but got %d", n)
+	}
+	avg := report.AcrossIterations["execution_time_ms"]["avg"]
+	if avg.Mean != 2.5 || avg.StdDev != 0 {
+		t.Errorf("expected a mean average of 2.5ms without deviation but got %+v", avg)
+	}
+	if last := report.Queries[len(report.Queries)-1].Iteration; last != 3 {
+		t.Errorf("expected the last query to be from iteration 3 but got %d", last)
+	}
+}
+
+func TestBenchmarkCommandCancel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+	mock.ExpectQuery("EXPLAIN").WillDelayFor(time.Minute).WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var out bytes.Buffer
+	cmd := &BenchmarkCommand{
+		CSV:         testCSVFile(t, testCSVData),
+		DB:          db,
+		Concurrency: 1,
+		Reporter:    JSONReporter{},
+		Output:      &out,
+		Log:         io.Discard,
+	}
+
+	if err := cmd.Exec(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the benchmark to be cancelled but got %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal("failed to decode report:", err)
+	}
+	if !report.Partial {
+		t.Error("expected the report to be partial")
+	}
+	if n := len(report.Queries); n != 1 {
+		t.Errorf("expected the query completed before cancellation to be reported but got %d queries", n)
+	}
+}
+
+func TestBenchmarkCommandErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+	mock.ExpectQuery("EXPLAIN").WillReturnError(&pq.Error{Code: "42P01", Message: `relation "cpu_usage" does not exist`})
+	mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`not json`))
+
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2}, testCSVData)
+
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 1 {
+		t.Errorf("expected failed queries to be excluded from stats but got %d queries", n)
+	}
+	errs := report.Errors.Global
+	if errs.Total != 2 || errs.ByClass["sql"] != 1 || errs.ByClass["plan_parse"] != 1 {
+		t.Errorf("expected a SQL error and a plan parse error but got %+v", errs)
+	}
+	if n := len(report.Errors.Workers); n != 2 {
+		t.Errorf("expected error counts for 2 workers but got %d", n)
+	}
+	if report.Errors.Examples["sql"] == "" {
+		t.Error("expected an example SQL error message")
+	}
+}
+
+func TestBenchmarkCommandMaxErrors(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	// Without expectations every query fails, and the workload is replayed until the benchmark aborts.
+
+	var out bytes.Buffer
+	cmd := &BenchmarkCommand{
+		CSV:         testCSVFile(t, testCSVData),
+		DB:          db,
+		Concurrency: 1,
+		Duration:    time.Minute,
+		MaxErrors:   5,
+		Reporter:    JSONReporter{},
+		Output:      &out,
+		Log:         io.Discard,
+	}
+
+	if err := cmd.Exec(context.Background()); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the benchmark to abort on errors but got %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal("failed to decode report:", err)
+	}
+	if !report.Partial {
+		t.Error("expected the report to be partial")
+	}
+	if n := report.Errors.Global.Total; n <= 5 || n > 7 {
+		t.Errorf("expected the benchmark to abort after 6 errors but got %d", n)
+	}
+}
+
+func TestBenchmarkCommandRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	mock.ExpectQuery("EXPLAIN").WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+	mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+
+	cmd := &BenchmarkCommand{
+		DB:          db,
+		Concurrency: 1,
+		Retry:       RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	}
+	report := runJSONBenchmark(t, cmd, "host_000001,2017-01-01 08:59:22,2017-01-01 09:59:22\n")
+
+	if n := len(report.Queries); n != 1 {
+		t.Fatalf("expected 1 query result but got %d", n)
+	}
+	if q := report.Queries[0]; q.Error != "" || q.Attempts != 2 {
+		t.Errorf("expected the query to succeed on the second attempt but got %d attempts: %s", q.Attempts, q.Error)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBenchmarkCommandQueryTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	mock.ExpectQuery("EXPLAIN").WillDelayFor(time.Minute).WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+
+	cmd := &BenchmarkCommand{
+		DB:           db,
+		Concurrency:  1,
+		QueryTimeout: 50 * time.Millisecond,
+		Retry:        RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	}
+	report := runJSONBenchmark(t, cmd, "host_000001,2017-01-01 08:59:22,2017-01-01 09:59:22\n")
+
+	if n := len(report.Queries); n != 1 {
+		t.Fatalf("expected 1 query result but got %d", n)
+	}
+	q := report.Queries[0]
+	if !q.TimedOut || q.ErrorClass != string(ErrorTimeout) {
+		t.Errorf("expected the query to time out but got %+v", q)
+	}
+	if q.Attempts != 1 {
+		t.Errorf("expected a timed out query not to be retried but got %d attempts", q.Attempts)
+	}
+	if n := report.Errors.Global.ByClass["timeout"]; n != 1 {
+		t.Errorf("expected 1 timeout error but got %d", n)
+	}
+}
+
+func TestBenchmarkCommandLPTCost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	// Each query is planned once to estimate its cost, then executed.
+	expectExplain(mock, 3+3)
+
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Balancer: "lpt", Weight: "cost"}, testCSVData)
+
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 3 {
+		t.Errorf("expected 3 queries in stats but got %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBenchmarkCommandMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3)
+
+	cmd := &BenchmarkCommand{DB: db, Concurrency: 2, MetricsAddr: "127.0.0.1:0"}
+	runJSONBenchmark(t, cmd, testCSVData)
+
+	var buf bytes.Buffer
+	if _, err := cmd.metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `ts_query_workers_query_execution_seconds_count{worker="0",template="min_max"}`) {
+		t.Errorf("expected the queries to be observed by the metrics:\n%s", buf.String())
+	}
+}
+
+func TestBenchmarkCommandMetricsWarmup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 2+3)
+
+	cmd := &BenchmarkCommand{DB: db, Concurrency: 2, WarmupQueries: 2, MetricsAddr: "127.0.0.1:0"}
+	runJSONBenchmark(t, cmd, testCSVData)
+
+	queries := 0
+	for _, n := range cmd.metrics.queries {
+		queries += n
+	}
+	if queries != 3 {
+		t.Errorf("expected the metrics to observe the 3 queries of the benchmark but not the warm-up, but got %d", queries)
+	}
+}
+
+func TestBenchmarkCommandQueryLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3)
+
+	var log, queryLog bytes.Buffer
+	cmd := &BenchmarkCommand{
+		CSV:         testCSVFile(t, testCSVData),
+		DB:          db,
+		Concurrency: 2,
+		Output:      io.Discard,
+		Log:         &log,
+		QueryLog:    &queryLog,
+	}
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(queryLog.String(), "✅"); n != 3 {
+		t.Errorf("expected 3 query results in the query log but got %d:\n%s", n, queryLog.String())
+	}
+	if strings.Contains(log.String(), "✅") {
+		t.Errorf("expected no query results in the log:\n%s", log.String())
+	}
+	if !strings.Contains(log.String(), "3/3 (100%) queries") {
+		t.Errorf("expected the progress in the log:\n%s", log.String())
+	}
+}
+
+func TestBenchmarkCommandQueryLogClosed(t *testing.T) {
+	queryLog, err := os.Create(filepath.Join(t.TempDir(), "queries.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The query log is closed even if the benchmark fails before it starts.
+	cmd := &BenchmarkCommand{CSV: testCSVFile(t, testCSVData), Concurrency: 0, QueryLog: queryLog}
+	if err := cmd.Exec(context.Background()); err == nil {
+		t.Fatal("expected the benchmark to fail without workers")
+	}
+	if err := queryLog.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the query log to be closed but got %v", err)
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+	"github.com/sbward/ts-query-workers/stats"
+)
+
+// Percentiles are the percentiles reported for each statistic.
+var Percentiles = []float64{50, 90, 95, 99, 99.9}
+
+// BenchmarkStats are the statistics of every metric collected from query execution results.
+type BenchmarkStats struct {
+	// ExecTime is the server-side execution time reported by EXPLAIN ANALYZE.
+	ExecTime WorkerStats[time.Duration]
+
+	// PlanningTime is the server-side planning time reported by EXPLAIN ANALYZE.
+	PlanningTime WorkerStats[time.Duration]
+
+	// Cost is the total cost estimated by the query planner.
+	Cost WorkerStats[float32]
+
+	// Latency is the time from the scheduled start of a query until its result was received,
+	// which includes time spent waiting for a busy worker.
+	Latency WorkerStats[time.Duration]
+
+	// RoundTrip is the time the client spent executing a query and receiving every row.
+	RoundTrip WorkerStats[time.Duration]
+
+	// Rows and Bytes are the number of rows received by the client and the size of their values.
+	Rows  WorkerStats[int]
+	Bytes WorkerStats[int64]
+
+	// Overhead is the time of the RoundTrip that was not spent planning or executing the query on the server.
+	Overhead WorkerStats[time.Duration]
+
+	// Mode is how the queries were measured, which selects the reported Metrics. If empty, ModeExplain is assumed.
+	Mode string
+
+	// Errors counts failed queries, which are excluded from the other metrics.
+	Errors ErrorStats
+
+	// Makespan is the wall-clock time from the start of the benchmark until every worker finished.
+	Makespan time.Duration
+
+	// Busy is the time each worker spent executing queries, including failed queries.
+	Busy []time.Duration
+
+	// Nodes summarize the plan nodes of every successful query by node type.
+	Nodes map[string]device.NodeStats
+
+	// Chunks relate the number of hypertable chunks scanned by each query to its execution time.
+	Chunks ChunkStats
+}
+
+func newBenchmarkStats(numWorkers int, exact bool) BenchmarkStats {
+	return BenchmarkStats{
+		ExecTime:     newWorkerStats[time.Duration](numWorkers, exact),
+		PlanningTime: newWorkerStats[time.Duration](numWorkers, exact),
+		Cost:         newWorkerStats[float32](numWorkers, exact),
+		Latency:      newWorkerStats[time.Duration](numWorkers, exact),
+		RoundTrip:    newWorkerStats[time.Duration](numWorkers, exact),
+		Rows:         newWorkerStats[int](numWorkers, exact),
+		Bytes:        newWorkerStats[int64](numWorkers, exact),
+		Overhead:     newWorkerStats[time.Duration](numWorkers, exact),
+		Errors:       newErrorStats(numWorkers),
+		Busy:         make([]time.Duration, numWorkers),
+		Nodes:        map[string]device.NodeStats{},
+		Chunks:       newChunkStats(exact),
+	}
+}
+
+// Push aggregates the statistics of a query execution result.
+// Failed queries are only counted by their error class.
+func (b *BenchmarkStats) Push(result *QueryExecutionResult) {
+	b.Busy[result.Worker] += result.Elapsed
+
+	if result.Error != nil {
+		b.Errors.Push(result.Worker, result.Error)
+		return
+	}
+	b.Latency.Push(result.Worker, result.Latency)
+
+	if executed(result.Mode) {
+		b.RoundTrip.Push(result.Worker, result.Stats.RoundTrip)
+		b.Rows.Push(result.Worker, result.Stats.Rows)
+		b.Bytes.Push(result.Worker, result.Stats.Bytes)
+	}
+	if !explained(result.Mode) {
+		return
+	}
+	if executed(result.Mode) {
+		b.Overhead.Push(result.Worker, result.Stats.Overhead())
+	}
+
+	b.ExecTime.Push(result.Worker, result.Stats.ExecutionTime)
+	b.PlanningTime.Push(result.Worker, result.Stats.PlanningTime)
+	b.Cost.Push(result.Worker, result.Stats.Cost)
+
+	if result.Stats.Plan != nil {
+		for nodeType, node := range result.Stats.Plan.Breakdown() {
+			total := b.Nodes[nodeType]
+			total.Add(node)
+			b.Nodes[nodeType] = total
+		}
+		start, end := result.Query.TimeRange()
+		b.Chunks.Push(result.Stats.Plan.Chunks(), result.Stats.Plan.ChunksExcluded(), start, end, result.Stats.ExecutionTime)
+	}
+}
+
+// AggregateStats aggregates query statistics from the results channel until it closes, then returns the final BenchmarkStats.
+// Each result is also passed to the observers in order, after it is aggregated.
+// If exact is true, exact percentiles are computed by keeping every value in memory.
+func aggregateStats(numWorkers int, exact bool, results <-chan *QueryExecutionResult, observers ...func(*QueryExecutionResult)) BenchmarkStats {
+	b := newBenchmarkStats(numWorkers, exact)
+
+	for result := range results {
+		b.Push(result)
+		for _, observe := range observers {
+			observe(result)
+		}
+	}
+
+	return b
+}
+
+// Metric is one of the statistics tables of BenchmarkStats.
+type Metric struct {
+	// Key identifies the metric in machine-readable reports, including its unit, e.g. "execution_time_ms".
+	Key string
+
+	// Title describes the metric in human-readable reports.
+	Title string
+
+	stats  workerStatsTable
+	format tableFormat
+	scale  func(float64) float64 // Converts a value to the unit of the Key.
+}
+
+// Metrics returns the statistics tables measured in the Mode, in the order they are reported.
+func (b BenchmarkStats) Metrics() []Metric {
+	var metrics []Metric
+	if explained(b.Mode) {
+		metrics = append(metrics,
+			Metric{"execution_time_ms", "Execution time", b.ExecTime, durationFormat, durationMillis},
+			Metric{"planning_time_ms", "Planning time", b.PlanningTime, durationFormat, durationMillis},
+			Metric{"cost", "Execution cost", b.Cost, intFormat, identity},
+		)
+	}
+	metrics = append(metrics, Metric{"latency_ms", "Latency from scheduled start", b.Latency, durationFormat, durationMillis})
+	if executed(b.Mode) {
+		metrics = append(metrics,
+			Metric{"round_trip_ms", "Round trip", b.RoundTrip, durationFormat, durationMillis},
+			Metric{"rows", "Rows received", b.Rows, intFormat, identity},
+			Metric{"bytes", "Bytes received", b.Bytes, intFormat, identity},
+		)
+	}
+	if explained(b.Mode) && executed(b.Mode) {
+		metrics = append(metrics, Metric{"overhead_ms", "Round trip minus server time", b.Overhead, durationFormat, durationMillis})
+	}
+	return metrics
+}
+
+// AllMetrics returns the statistics tables of every mode.
+func allMetrics() []Metric {
+	return BenchmarkStats{Mode: ModeBoth}.Metrics()
+}
+
+// Table returns a human-readable table with a row for all workers followed by a row per worker.
+func (m Metric) Table() string {
+	table := tableHeader()
+	table += m.tableLine("ALL", m.stats.row(allWorkers))
+
+	for worker := 0; worker < m.stats.numWorkers(); worker++ {
+		table += m.tableLine(fmt.Sprint(worker), m.stats.row(worker))
+	}
+
+	return table
+}
+
+// Row returns the value of each column for a worker, or for all workers, in the unit of the Key.
+func (m Metric) Row(worker int) []float64 {
+	row := m.stats.row(worker)
+	for i := 1; i < len(row); i++ {
+		row[i] = m.scale(row[i])
+	}
+	return row
+}
+
+// FormatKeyUnit formats a value in the unit of the Key for a human-readable table.
+func (m Metric) formatKeyUnit(x float64) string {
+	return m.format.value(x / m.scale(1))
+}
+
+// Workers returns the number of workers with a row in the table.
+func (m Metric) Workers() int {
+	return m.stats.numWorkers()
+}
+
+// AllWorkers selects the row of all workers from a statistics table.
+const allWorkers = -1
+
+// ColumnLabels are the labels of the columns of a statistics table.
+func columnLabels() []string {
+	labels := []string{"Queries", "Total", "Minimum", "Maximum", "Average"}
+	for _, p := range Percentiles {
+		labels = append(labels, percentileLabel(p))
+	}
+	return labels
+}
+
+// ColumnKeys identify the columns of a statistics table in machine-readable reports.
+func columnKeys() []string {
+	keys := []string{"count", "total", "min", "max", "avg"}
+	for _, p := range Percentiles {
+		keys = append(keys, percentileLabel(p))
+	}
+	return keys
+}
+
+func durationMillis(ns float64) float64 {
+	return ns / float64(time.Millisecond)
+}
+
+func identity(x float64) float64 {
+	return x
+}
+
+// WorkerStatsTable is implemented by WorkerStats of any type.
+type workerStatsTable interface {
+	row(worker int) []float64
+	numWorkers() int
+}
+
+// WorkerStats aggregates the values of a metric across all workers and for each worker.
+type WorkerStats[T stats.Divisible] struct {
+	Global   *stats.Aggregator[T]
+	ByWorker []*stats.Aggregator[T]
+
+	newAggregator func() *stats.Aggregator[T]
+}
+
+func newWorkerStats[T stats.Divisible](numWorkers int, exact bool) WorkerStats[T] {
+	newAggregator := stats.NewAggregator[T]
+	if exact {
+		newAggregator = stats.NewExactAggregator[T]
+	}
+	return WorkerStats[T]{
+		Global:        newAggregator(),
+		ByWorker:      make([]*stats.Aggregator[T], numWorkers),
+		newAggregator: newAggregator,
+	}
+}
+
+// Push adds a value measured by a worker.
+func (w *WorkerStats[T]) Push(worker int, x T) {
+	w.Global.Push(x)
+
+	agg := w.ByWorker[worker]
+	if agg == nil {
+		agg = w.newAggregator()
+		w.ByWorker[worker] = agg
+	}
+	agg.Push(x)
+}
+
+// Idle returns the time a worker spent without executing queries during the makespan.
+func (b BenchmarkStats) Idle(worker int) time.Duration {
+	if idle := b.Makespan - b.Busy[worker]; idle > 0 {
+		return idle
+	}
+	return 0
+}
+
+// ScheduleTable returns a human-readable table of the time each worker spent busy and idle during the makespan.
+func (b BenchmarkStats) scheduleTable() string {
+	table := "| Worker |     Busy |     Idle | Utilization |\n"
+	table += "|--------|----------|----------|-------------|\n"
+	for worker, busy := range b.Busy {
+		utilization := 0.0
+		if b.Makespan > 0 {
+			utilization = float64(busy) / float64(b.Makespan) * 100
+		}
+		table += fmt.Sprintf("| %6d | %8s | %8s | %10.1f%% |\n",
+			worker, busy.Round(time.Millisecond), b.Idle(worker).Round(time.Millisecond), utilization)
+	}
+	return table
+}
+
+// NodeTypes returns the node types of the plan nodes, by descending time spent in them.
+func (b BenchmarkStats) nodeTypes() []string {
+	types := make([]string, 0, len(b.Nodes))
+	for nodeType := range b.Nodes {
+		types = append(types, nodeType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		ti, tj := b.Nodes[types[i]].Time, b.Nodes[types[j]].Time
+		if ti != tj {
+			return ti > tj
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// NodesTable returns a human-readable table of the plan nodes of every query by node type: the time spent
+// in the nodes excluding their children and its share of the total, the estimated and actual rows,
+// and the shared buffer blocks that were hits or reads.
+func (b BenchmarkStats) nodesTable() string {
+	var total time.Duration
+	for _, node := range b.Nodes {
+		total += node.Time
+	}
+
+	table := "|                      Node type |   Nodes |       Time |  Share | Est. rows | Actual rows | Shared hit | Shared read |\n"
+	table += "|--------------------------------|---------|------------|--------|-----------|-------------|------------|-------------|\n"
+	for _, nodeType := range b.nodeTypes() {
+		node := b.Nodes[nodeType]
+		share := 0.0
+		if total > 0 {
+			share = float64(node.Time) / float64(total) * 100
+		}
+		table += fmt.Sprintf("| %30s | %7d | %10s | %5.1f%% | %9d | %11d | %10d | %11d |\n",
+			nodeType, node.Nodes, node.Time.Round(time.Microsecond), share,
+			node.PlanRows, node.ActualRows, node.SharedHitBlocks, node.SharedReadBlocks)
+	}
+	return table
+}
+
+// ChunkStats aggregate the execution time of queries by the number of hypertable chunks they scanned.
+type ChunkStats struct {
+	// Known are the distinct chunks scanned by any query, which is a lower bound of the chunks of the hypertable.
+	Known map[string]bool
+
+	// ByCount maps a number of chunks to the execution time of the queries that scanned that many.
+	ByCount map[int]*stats.Aggregator[time.Duration]
+
+	// Start and End are the span of the time ranges of the queries, which is a lower bound of the time range of the dataset.
+	Start, End time.Time
+
+	// Unexcluding counts the queries with a time range that didn't exclude any chunk at runtime by the number of
+	// chunks they scanned, and covering counts those whose time range covers the whole span.
+	unexcluding, covering map[int]int
+
+	newAggregator func() *stats.Aggregator[time.Duration]
+}
+
+func newChunkStats(exact bool) ChunkStats {
+	newAggregator := stats.NewAggregator[time.Duration]
+	if exact {
+		newAggregator = stats.NewExactAggregator[time.Duration]
+	}
+	return ChunkStats{
+		Known:         map[string]bool{},
+		ByCount:       map[int]*stats.Aggregator[time.Duration]{},
+		unexcluding:   map[int]int{},
+		covering:      map[int]int{},
+		newAggregator: newAggregator,
+	}
+}
+
+// Push adds the chunks scanned by a query, the number of chunks its plan excluded at runtime, its time range
+// and its execution time. The time range is zero if the query doesn't have one.
+func (c *ChunkStats) Push(chunks []string, excluded int, start, end time.Time, executionTime time.Duration) {
+	for _, chunk := range chunks {
+		c.Known[chunk] = true
+	}
+	agg, ok := c.ByCount[len(chunks)]
+	if !ok {
+		agg = c.newAggregator()
+		c.ByCount[len(chunks)] = agg
+	}
+	agg.Push(executionTime)
+
+	if start.IsZero() && end.IsZero() {
+		return
+	}
+
+	// Queries that covered the span no longer do once it is wider.
+	if c.Start.IsZero() || start.Before(c.Start) {
+		c.Start = start
+		c.covering = map[int]int{}
+	}
+	if c.End.IsZero() || end.After(c.End) {
+		c.End = end
+		c.covering = map[int]int{}
+	}
+
+	if excluded == 0 {
+		c.unexcluding[len(chunks)]++
+		if !c.narrower(start, end) {
+			c.covering[len(chunks)]++
+		}
+	}
+}
+
+// Unexcluded returns whether chunk exclusion didn't happen for a query: its plan scanned every known chunk
+// without excluding any at runtime, although its time range is narrower than the span of the queries.
+// A query whose time range covers the span needs every chunk, and one without a time range isn't flagged.
+func (c ChunkStats) unexcluded(chunks, excluded int, start, end time.Time) bool {
+	if start.IsZero() && end.IsZero() {
+		return false
+	}
+	return chunks > 0 && chunks == len(c.Known) && excluded == 0 && c.narrower(start, end)
+}
+
+// Narrower returns whether a time range is narrower than the span of the queries.
+func (c ChunkStats) narrower(start, end time.Time) bool {
+	return start.After(c.Start) || end.Before(c.End)
+}
+
+// UnexcludedQueries returns the number of queries for which chunk exclusion didn't happen.
+func (c ChunkStats) unexcludedQueries() int {
+	if len(c.Known) == 0 {
+		return 0
+	}
+	return c.unexcluding[len(c.Known)] - c.covering[len(c.Known)]
+}
+
+// Counts returns the numbers of chunks scanned by any query in ascending order.
+func (c ChunkStats) counts() []int {
+	counts := make([]int, 0, len(c.ByCount))
+	for n := range c.ByCount {
+		counts = append(counts, n)
+	}
+	sort.Ints(counts)
+	return counts
+}
+
+// Table returns a human-readable table of the execution time of queries by the number of chunks they scanned,
+// followed by the number of queries without chunk exclusion.
+func (c ChunkStats) table() string {
+	table := "| Chunks | Queries |    Average |        p50 |        p99 |    Maximum |\n"
+	table += "|--------|---------|------------|------------|------------|------------|\n"
+	for _, n := range c.counts() {
+		agg := c.ByCount[n]
+		table += fmt.Sprintf("| %6d | %7d | %10s | %10s | %10s | %10s |\n", n, agg.Count,
+			durationFormat.value(agg.Avg), durationFormat.value(agg.Percentile(50)),
+			durationFormat.value(agg.Percentile(99)), durationFormat.value(float64(agg.Max)))
+	}
+	if n := c.unexcludedQueries(); n > 0 {
+		table += fmt.Sprintf("\n%d queries scanned all %d chunks seen during the benchmark although their time range was narrower "+
+			"than the span of the queries, so chunk exclusion didn't happen.\n", n, len(c.Known))
+	}
+	return table
+}
+
+// ErrorStats counts failed queries by ErrorClass across all workers and for each worker.
+type ErrorStats struct {
+	Global   ErrorCounts
+	ByWorker []ErrorCounts
+
+	// Examples holds the first error message of each class.
+	Examples map[ErrorClass]string
+}
+
+// ErrorCounts maps each ErrorClass to a number of failed queries.
+type ErrorCounts map[ErrorClass]int
+
+// Total returns the number of failed queries of every class.
+func (c ErrorCounts) Total() int {
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+func newErrorStats(numWorkers int) ErrorStats {
+	e := ErrorStats{
+		Global:   ErrorCounts{},
+		ByWorker: make([]ErrorCounts, numWorkers),
+		Examples: map[ErrorClass]string{},
+	}
+	for worker := range e.ByWorker {
+		e.ByWorker[worker] = ErrorCounts{}
+	}
+	return e
+}
+
+// Push counts an error returned to a worker.
+func (e *ErrorStats) Push(worker int, err error) {
+	class := classifyError(err)
+	e.Global[class]++
+	e.ByWorker[worker][class]++
+	if _, ok := e.Examples[class]; !ok {
+		e.Examples[class] = err.Error()
+	}
+}
+
+// Counts returns the error counts of a worker, or of all workers.
+func (e ErrorStats) Counts(worker int) ErrorCounts {
+	if worker == allWorkers {
+		return e.Global
+	}
+	return e.ByWorker[worker]
+}
+
+// ErrorsTable returns a human-readable table of the number of queries and errors of each class,
+// with a row for all workers followed by a row per worker. The executed queries are taken from b.
+func (b BenchmarkStats) errorsTable() string {
+	header := "| Worker | Queries | Errors |"
+	divider := "|--------|---------|--------|"
+	for _, class := range ErrorClasses {
+		header += fmt.Sprintf(" %10s |", class)
+		divider += "------------|"
+	}
+	table := header + "\n" + divider + "\n"
+
+	line := func(label string, worker int) string {
+		counts := b.Errors.Counts(worker)
+		queries := int(b.Latency.row(worker)[0]) + counts.Total()
+		line := fmt.Sprintf("| %6s | %7d | %6d |", label, queries, counts.Total())
+		for _, class := range ErrorClasses {
+			line += fmt.Sprintf(" %10d |", counts[class])
+		}
+		return line + "\n"
+	}
+
+	table += line("ALL", allWorkers)
+	for worker := range b.Errors.ByWorker {
+		table += line(fmt.Sprint(worker), worker)
+	}
+
+	return table
+}
+
+// ErrorSummary returns the errors table followed by an example message of each error class that occurred.
+func (b BenchmarkStats) errorSummary() string {
+	summary := b.errorsTable()
+	for _, class := range ErrorClasses {
+		if example, ok := b.Errors.Examples[class]; ok {
+			summary += fmt.Sprintf("\n%s: %s", class, example)
+		}
+	}
+	return summary + "\n"
+}
+
+// TableFormat formats the total and the other values in a row of a statistics table.
+type tableFormat struct {
+	total func(float64) string
+	value func(float64) string
+}
+
+var durationFormat = tableFormat{
+	total: func(x float64) string { return time.Duration(x).Round(time.Millisecond).String() },
+	value: func(x float64) string { return time.Duration(x).Round(time.Microsecond).String() },
+}
+
+var intFormat = tableFormat{
+	total: func(x float64) string { return strconv.Itoa(int(x)) },
+	value: func(x float64) string { return strconv.Itoa(int(x)) },
+}
+
+// Row returns the value of each column for a worker, or for all workers.
+func (w WorkerStats[T]) row(worker int) []float64 {
+	agg := w.Global
+	if worker != allWorkers {
+		agg = w.ByWorker[worker]
+	}
+	if agg == nil {
+		agg = &stats.Aggregator[T]{}
+	}
+	row := []float64{
+		float64(agg.Count),
+		float64(agg.Total),
+		float64(agg.Min),
+		float64(agg.Max),
+		agg.Avg,
+	}
+	for _, p := range Percentiles {
+		row = append(row, agg.Percentile(p))
+	}
+	return row
+}
+
+func (w WorkerStats[T]) numWorkers() int {
+	return len(w.ByWorker)
+}
+
+func tableHeader() string {
+	header := "| Worker | Queries | Total | Minimum | Maximum | Average |"
+	divider := "|--------|---------|-------|---------|---------|---------|"
+	for _, p := range Percentiles {
+		header += fmt.Sprintf(" %7s |", percentileLabel(p))
+		divider += "---------|"
+	}
+	return header + "\n" + divider + "\n"
+}
+
+// PercentileLabel formats a percentile as a column label, e.g. "p99.9".
+func percentileLabel(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func (m Metric) tableLine(worker string, row []float64) string {
+	line := fmt.Sprintf(
+		"| %6s | %7d | %5s | %7s | %7s | %7s |",
+		worker,
+		int(row[0]),
+		m.format.total(row[1]),
+		m.format.value(row[2]),
+		m.format.value(row[3]),
+		m.format.value(row[4]),
+	)
+	for _, x := range row[5:] {
+		line += fmt.Sprintf(" %7s |", m.format.value(x))
+	}
+	return line + "\n"
+}
@@ -4,18 +4,35 @@ import "fmt"
 
 // Buckets divides a slice of values into N buckets with the provided Balancer.
 func Buckets[T any](values []T, n int, balance Balancer) ([][]T, error) {
+	assignments, err := Assign(values, n, balance)
+	if err != nil {
+		return nil, err
+	}
+
 	buckets := make([][]T, n)
 
-	for _, value := range values {
-		i, err := balance(value, n)
+	for i, value := range values {
+		bucket := assignments[i]
+		if buckets[bucket] == nil {
+			buckets[bucket] = []T{}
+		}
+		buckets[bucket] = append(buckets[bucket], value)
+	}
+
+	return buckets, nil
+}
+
+// Assign returns the index of the bucket assigned to each value by the provided Balancer, out of N buckets.
+func Assign[T any](values []T, n int, balance Balancer) ([]int, error) {
+	assignments := make([]int, len(values))
+
+	for i, value := range values {
+		bucket, err := balance(value, n)
 		if err != nil {
 			return nil, fmt.Errorf("balancer failed to assign value '%v': %w", value, err)
 		}
-		if buckets[i] == nil {
-			buckets[i] = []T{}
-		}
-		buckets[i] = append(buckets[i], value)
+		assignments[i] = bucket
 	}
 
-	return buckets, nil
+	return assignments, nil
 }
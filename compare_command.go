@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sbward/ts-query-workers/stats"
+)
+
+// ErrRegression is returned by CompareCommand when the candidate report regressed from the baseline.
+var ErrRegression = errors.New("performance regression detected")
+
+// CompareCommand compares a candidate JSON benchmark report against a baseline report.
+// For each metric it prints the change of a statistic per worker and across all workers,
+// and tests whether the per-query samples of both reports differ significantly.
+type CompareCommand struct {
+	// Baseline is a JSON report written by JSONReporter, e.g. from the main branch.
+	Baseline io.Reader
+
+	// Candidate is a JSON report written by JSONReporter to compare against the Baseline.
+	Candidate io.Reader
+
+	// Metrics are the keys of the metrics to compare. Optional, defaults to execution_time_ms and cost.
+	Metrics []string
+
+	// Statistic is the key of the column compared between reports, e.g. "p50" or "avg". Optional, defaults to "p50".
+	Statistic string
+
+	// Threshold is the relative increase of the Statistic, e.g. 0.1 for 10%, above which
+	// a significant change is a regression.
+	Threshold float64
+
+	// Alpha is the significance level of the Mann-Whitney U test on the per-query samples. Optional, defaults to 0.05.
+	Alpha float64
+
+	// Output is the destination of the comparison. Optional, defaults to stdout.
+	Output io.Writer
+}
+
+// NewCompareCommandFromCLI reads the configuration of a CompareCommand from the arguments following "compare".
+func NewCompareCommandFromCLI(args []string) (*CompareCommand, error) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := flags.Float64("threshold", 0.1, "relative increase of the statistic above which a significant change is a regression, e.g. 0.1 for 10%")
+	statistic := flags.String("stat", "p50", "statistic to compare: count, total, min, max, avg or a percentile such as p99")
+	alpha := flags.Float64("alpha", 0.05, "significance level of the test on per-query samples")
+	metrics := flags.String("metrics", "execution_time_ms,cost", "comma-separated keys of the metrics to compare")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ts-query-workers compare [options] BASELINE.json CANDIDATE.json")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return nil, errors.New("compare requires a baseline and a candidate report filename")
+	}
+
+	baseline, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := os.Open(flags.Arg(1))
+	if err != nil {
+		baseline.Close()
+		return nil, err
+	}
+
+	cmd := &CompareCommand{
+		Baseline:  baseline,
+		Candidate: candidate,
+		Metrics:   strings.Split(*metrics, ","),
+		Statistic: *statistic,
+		Threshold: *threshold,
+		Alpha:     *alpha,
+	}
+
+	return cmd, nil
+}
+
+// Exec prints the comparison and returns ErrRegression if any metric regressed.
+func (c *CompareCommand) Exec(ctx context.Context) error {
+	baseline, err := loadJSONReport(c.Baseline)
+	if err != nil {
+		return fmt.Errorf("failed to load baseline report: %w", err)
+	}
+	candidate, err := loadJSONReport(c.Candidate)
+	if err != nil {
+		return fmt.Errorf("failed to load candidate report: %w", err)
+	}
+
+	metrics := c.Metrics
+	if len(metrics) == 0 {
+		metrics = []string{"execution_time_ms", "cost"}
+	}
+	statistic := c.Statistic
+	if statistic == "" {
+		statistic = "p50"
+	}
+	alpha := c.Alpha
+	if alpha == 0 {
+		alpha = 0.05
+	}
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var regressions []string
+
+	for _, key := range metrics {
+		cmp, err := compareMetric(baseline, candidate, key, statistic)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "\n%s (%s):\n\n%s\n", metricTitle(key), statistic, cmp.table())
+
+		significant := cmp.test == nil || cmp.test.P < alpha
+		if cmp.test != nil {
+			verdict := "not significant"
+			if significant {
+				verdict = "significant"
+			}
+			fmt.Fprintf(out, "Mann-Whitney U test of %d vs %d queries: p = %.4f (%s at α = %g)\n",
+				cmp.baselineSamples, cmp.candidateSamples, cmp.test.P, verdict, alpha)
+		}
+
+		if change := cmp.global.change(); significant && change > c.Threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %s %+.1f%%", key, statistic, change*100))
+		}
+	}
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("%w: %s exceeds threshold of %.1f%%", ErrRegression, strings.Join(regressions, ", "), c.Threshold*100)
+	}
+
+	fmt.Fprintln(out, "\nNo regressions detected.")
+
+	return nil
+}
+
+// LoadJSONReport decodes a report written by JSONReporter.
+func loadJSONReport(r io.Reader) (*jsonReport, error) {
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	report := &jsonReport{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// MetricTitle returns the human-readable title of a metric key, or the key if it is unknown.
+func metricTitle(key string) string {
+	for _, m := range allMetrics() {
+		if m.Key == key {
+			return m.Title
+		}
+	}
+	return key
+}
+
+// QuerySamples maps metric keys to the value of the metric in a query result.
+var querySamples = map[string]func(q jsonQueryResult) float64{
+	"execution_time_ms": func(q jsonQueryResult) float64 { return q.ExecutionTimeMillis },
+	"planning_time_ms":  func(q jsonQueryResult) float64 { return q.PlanningTimeMillis },
+	"cost":              func(q jsonQueryResult) float64 { return q.Cost },
+	"latency_ms":        func(q jsonQueryResult) float64 { return q.LatencyMillis },
+	"round_trip_ms":     func(q jsonQueryResult) float64 { return q.RoundTripMillis },
+	"rows":              func(q jsonQueryResult) float64 { return float64(q.Rows) },
+	"bytes":             func(q jsonQueryResult) float64 { return float64(q.Bytes) },
+	"overhead_ms":       func(q jsonQueryResult) float64 { return q.OverheadMillis },
+}
+
+// Samples returns the value of a metric for every successful query in the report.
+func (r *jsonReport) samples(key string) []float64 {
+	sample, ok := querySamples[key]
+	if !ok {
+		return nil
+	}
+	xs := make([]float64, 0, len(r.Queries))
+	for _, q := range r.Queries {
+		if q.Error == "" {
+			xs = append(xs, sample(q))
+		}
+	}
+	return xs
+}
+
+// Column returns the value of a column of the summary by its key.
+func (s jsonSummary) column(key string) (float64, bool) {
+	switch key {
+	case "count":
+		return float64(s.Count), true
+	case "total":
+		return s.Total, true
+	case "min":
+		return s.Min, true
+	case "max":
+		return s.Max, true
+	case "avg":
+		return s.Avg, true
+	}
+	x, ok := s.Percentiles[key]
+	return x, ok
+}
+
+// Delta is the change of a statistic from the baseline to the candidate.
+type delta struct {
+	worker    string
+	baseline  float64
+	candidate float64
+}
+
+// Change returns the relative change from the baseline, e.g. 0.1 for a 10% increase.
+func (d delta) change() float64 {
+	if d.baseline == 0 {
+		return 0
+	}
+	return (d.candidate - d.baseline) / d.baseline
+}
+
+// MetricComparison is the comparison of one metric between two reports.
+type metricComparison struct {
+	global  delta
+	workers []delta
+
+	// Test is the significance test of the per-query samples, or nil if either report has none.
+	test             *stats.MannWhitneyResult
+	baselineSamples  int
+	candidateSamples int
+}
+
+func compareMetric(baseline, candidate *jsonReport, key, statistic string) (*metricComparison, error) {
+	b, ok := baseline.Stats[key]
+	if !ok {
+		return nil, fmt.Errorf("baseline report has no metric %q", key)
+	}
+	c, ok := candidate.Stats[key]
+	if !ok {
+		return nil, fmt.Errorf("candidate report has no metric %q", key)
+	}
+
+	cmp := &metricComparison{}
+
+	newDelta := func(worker string, b, c jsonSummary) (delta, error) {
+		d := delta{worker: worker}
+		var ok bool
+		if d.baseline, ok = b.column(statistic); !ok {
+			return d, fmt.Errorf("unknown statistic %q", statistic)
+		}
+		d.candidate, _ = c.column(statistic)
+		return d, nil
+	}
+
+	var err error
+	if cmp.global, err = newDelta("ALL", b.Global, c.Global); err != nil {
+		return nil, err
+	}
+	for worker := 0; worker < len(b.Workers) && worker < len(c.Workers); worker++ {
+		d, _ := newDelta(fmt.Sprint(worker), b.Workers[worker], c.Workers[worker])
+		cmp.workers = append(cmp.workers, d)
+	}
+
+	bs, cs := baseline.samples(key), candidate.samples(key)
+	cmp.baselineSamples, cmp.candidateSamples = len(bs), len(cs)
+	if len(bs) > 0 && len(cs) > 0 {
+		test := stats.MannWhitneyU(cs, bs)
+		cmp.test = &test
+	}
+
+	return cmp, nil
+}
+
+func (m *metricComparison) table() string {
+	table := "| Worker |  Baseline | Candidate |     Delta |  Change |\n"
+	table += "|--------|-----------|-----------|-----------|---------|\n"
+	for _, d := range append([]delta{m.global}, m.workers...) {
+		table += fmt.Sprintf("| %6s | %9.3f | %9.3f | %+9.3f | %+6.1f%% |\n",
+			d.worker, d.baseline, d.candidate, d.candidate-d.baseline, d.change()*100)
+	}
+	return table
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// TestJSONReportData writes a JSON report of n queries with execution times around the mean.
+func testJSONReportData(t *testing.T, n int, mean time.Duration, seed int64) *bytes.Buffer {
+	t.Helper()
+
+	r := rand.New(rand.NewSource(seed))
+	ch := make(chan *QueryExecutionResult, n)
+	report := &Report{}
+	for i := 0; i < n; i++ {
+		result := &QueryExecutionResult{
+			Query:  &device.MinMaxCPUQuery{Hostname: "host_000001"},
+			Stats:  &device.QueryStats{ExecutionTime: time.Duration((0.8 + 0.4*r.Float64()) * float64(mean)), Cost: 100},
+			Worker: i % 2,
+		}
+		ch <- result
+		report.Results = append(report.Results, result)
+	}
+	close(ch)
+	report.Stats = aggregateStats(2, true, ch)
+
+	var buf bytes.Buffer
+	if err := (JSONReporter{}).Report(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestCompareCommandRegression(t *testing.T) {
+	var out bytes.Buffer
+	cmd := &CompareCommand{
+		Baseline:  testJSONReportData(t, 100, 10*time.Millisecond, 1),
+		Candidate: testJSONReportData(t, 100, 13*time.Millisecond, 2),
+		Threshold: 0.1,
+		Output:    &out,
+	}
+
+	err := cmd.Exec(context.Background())
+	if !errors.Is(err, ErrRegression) {
+		t.Fatalf("expected a regression but got %v", err)
+	}
+	if !strings.Contains(err.Error(), "execution_time_ms") || strings.Contains(err.Error(), "cost") {
+		t.Errorf("expected only execution time to regress: %s", err)
+	}
+	if !strings.Contains(out.String(), "(significant at") {
+		t.Errorf("expected a significant difference to be reported:\n%s", out.String())
+	}
+}
+
+func TestCompareCommandNoRegression(t *testing.T) {
+	cmd := &CompareCommand{
+		Baseline:  testJSONReportData(t, 100, 10*time.Millisecond, 1),
+		Candidate: testJSONReportData(t, 100, 10*time.Millisecond, 2),
+		Threshold: 0.1,
+		Output:    io.Discard,
+	}
+
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal("expected no regression but got:", err)
+	}
+}
+
+func TestCompareCommandUnknownStatistic(t *testing.T) {
+	cmd := &CompareCommand{
+		Baseline:  testJSONReportData(t, 10, 10*time.Millisecond, 1),
+		Candidate: testJSONReportData(t, 10, 10*time.Millisecond, 2),
+		Statistic: "p42",
+		Output:    io.Discard,
+	}
+
+	if err := cmd.Exec(context.Background()); err == nil || errors.Is(err, ErrRegression) {
+		t.Fatalf("expected an unknown statistic error but got %v", err)
+	}
+}
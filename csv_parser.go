@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sbward/ts-query-workers/device"
@@ -11,62 +14,297 @@ import (
 
 const csvTimeFormat = "2006-01-02 15:04:05"
 
-type queryOption func(*device.MinMaxCPUQuery)
+// Time layouts accepted by CSVOptions besides the layouts of the time package.
+const (
+	// LayoutRFC3339 is an RFC 3339 timestamp with optional fractional seconds, e.g. "2017-01-01T08:59:22Z".
+	LayoutRFC3339 = "rfc3339"
 
-// QueriesFromCSV parses MinMaxCPUQueries from a CSV file.
-// Options can be provided to modify each query as they are read.
-func queriesFromCSV(r *csv.Reader, opts ...queryOption) ([]*device.MinMaxCPUQuery, error) {
-	out := make([]*device.MinMaxCPUQuery, 0)
+	// LayoutUnix is a number of seconds since the Unix epoch.
+	LayoutUnix = "unix"
 
+	// LayoutUnixMillis is a number of milliseconds since the Unix epoch.
+	LayoutUnixMillis = "unix_ms"
+)
+
+// CSVOptions configure how query specifications are read from a CSV file.
+// The zero value reads times in the csvTimeFormat layout in UTC, and fails on the first row that can't be read.
+type CSVOptions struct {
+	// TimeLayouts are the layouts of the start and end times, which are tried in order: a layout of the time
+	// package, LayoutRFC3339, LayoutUnix or LayoutUnixMillis. Optional, defaults to csvTimeFormat.
+	TimeLayouts []string
+
+	// Location is the time zone of times without a zone offset. Optional, defaults to UTC.
+	Location *time.Location
+
+	// Lenient skips rows that can't be read, instead of failing, so they can be reported after reading the file.
+	Lenient bool
+}
+
+// ParseTime parses a time with the first of the TimeLayouts that matches.
+func (o CSVOptions) parseTime(s string) (time.Time, error) {
+	layouts := o.TimeLayouts
+	if len(layouts) == 0 {
+		layouts = []string{csvTimeFormat}
+	}
+	loc := o.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		switch layout {
+		case LayoutUnix, LayoutUnixMillis:
+			var n int64
+			if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+				continue
+			}
+			if layout == LayoutUnix {
+				t = time.Unix(n, 0)
+			} else {
+				t = time.UnixMilli(n)
+			}
+			return t.In(loc), nil
+		case LayoutRFC3339:
+			layout = time.RFC3339Nano
+		}
+		if t, err = time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+type queryOption func(device.Params)
+
+// DefaultBucketSize sets the bucket size param of queries that don't specify one.
+func defaultBucketSize(size string) queryOption {
+	return func(p device.Params) {
+		if _, ok := p[device.ParamBucketSize]; !ok {
+			p[device.ParamBucketSize] = size
+		}
+	}
+}
+
+// QueryReader reads query specifications one at a time and binds them to a template,
+// so an input of any size can be read without holding every query in memory.
+type queryReader interface {
+	// Next returns the next query. When the end of the input is reached io.EOF will be returned.
+	next() (device.Query, error)
+
+	// Skipped returns the rows that were skipped so far because they couldn't be read.
+	skipped() skippedRows
+}
+
+// ReadQueries reads every query until the end of the input.
+func readQueries(qr queryReader) ([]device.Query, error) {
+	out := make([]device.Query, 0)
 	for {
-		query, err := nextQueryFromCSV(r)
+		query, err := qr.next()
 		if err == io.EOF {
-			break
+			return out, nil
 		}
 		if err != nil {
-			return out, fmt.Errorf("CSV parsing failed: %w", err)
-		}
-		for _, option := range opts {
-			option(query)
+			return out, err
 		}
 		out = append(out, query)
 	}
+}
+
+// MaxSkippedExamples is the number of errors of skipped rows that are kept to be reported.
+const maxSkippedExamples = 10
+
+// SkippedRows counts the rows of an input that were skipped because they couldn't be read,
+// and keeps the errors of the first few.
+type skippedRows struct {
+	count    int
+	examples []error
+}
+
+func (s *skippedRows) skip(err error) {
+	s.count++
+	if len(s.examples) < maxSkippedExamples {
+		s.examples = append(s.examples, err)
+	}
+}
+
+// RowError is an error of a single row of an input, which a lenient reader skips.
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+func (e *rowError) Unwrap() error {
+	return e.err
+}
 
-	return out, nil
+// QueriesFromCSV parses query specifications from a CSV file and binds them to the template.
+// Options can be provided to modify the params of each query as they are read.
+func queriesFromCSV(r *csv.Reader, tmpl device.Template, opts ...queryOption) ([]device.Query, error) {
+	return readQueries(newCSVQueries(r, CSVOptions{}, tmpl, opts...))
 }
 
-// NextQuery parses a device.MinMaxCPUQuery from the next CSV record in r.
+// CsvQueries binds the query params of each CSV record to a template.
+type csvQueries struct {
+	params  *csvQueryReader
+	tmpl    device.Template
+	opts    []queryOption
+	skips   skippedRows
+	lenient bool
+}
+
+func newCSVQueries(r *csv.Reader, options CSVOptions, tmpl device.Template, opts ...queryOption) *csvQueries {
+	// Rows of any length are read, so a short row is reported as a missing column.
+	r.FieldsPerRecord = -1
+	return &csvQueries{
+		params:  &csvQueryReader{r: r, options: options},
+		tmpl:    tmpl,
+		opts:    opts,
+		lenient: options.Lenient,
+	}
+}
+
+func (qs *csvQueries) next() (device.Query, error) {
+	for {
+		query, err := qs.read()
+		var rowErr *rowError
+		if err != nil && qs.lenient && errors.As(err, &rowErr) {
+			qs.skips.skip(rowErr)
+			continue
+		}
+		return query, err
+	}
+}
+
+func (qs *csvQueries) read() (device.Query, error) {
+	params, err := qs.params.next()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CSV parsing failed: %w", err)
+	}
+	for _, option := range qs.opts {
+		option(params)
+	}
+	query, err := qs.tmpl.Bind(params)
+	if err != nil {
+		line, _ := qs.params.r.FieldPos(0)
+		return nil, fmt.Errorf("CSV parsing failed: %w", &rowError{line, err})
+	}
+	return query, nil
+}
+
+func (qs *csvQueries) skipped() skippedRows {
+	return qs.skips
+}
+
+// CsvColumns are the columns of the params of every query.
+var csvColumns = []string{device.ParamHostname, device.ParamStartTime, device.ParamEndTime}
+
+// CsvQueryReader reads query params from CSV records, whose columns are named by an optional header row.
+type csvQueryReader struct {
+	r       *csv.Reader
+	options CSVOptions
+
+	// Columns maps the name of each param to the index of its column.
+	columns map[string]int
+
+	// Header names every column, or is nil if the file has no header row.
+	header []string
+}
+
+// Next parses device.Params from the next CSV record.
 // When the end of file is reached io.EOF will be returned.
-func nextQueryFromCSV(r *csv.Reader) (*device.MinMaxCPUQuery, error) {
-	record, err := r.Read()
+func (qr *csvQueryReader) next() (device.Params, error) {
+	record, err := qr.r.Read()
 	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowError{parseErr.Line, parseErr.Err}
+		}
 		return nil, err
 	}
 
-	// If the record is a header row, skip it and return the first data row.
-	if record[0] == "hostname" {
-		return nextQueryFromCSV(r)
+	// Map the columns by the header row if there is one, then return the first data row.
+	if qr.columns == nil {
+		if err := qr.mapColumns(record); err != nil {
+			return nil, err
+		}
+		if qr.header != nil {
+			return qr.next()
+		}
+	}
+
+	params := device.Params{}
+
+	for _, name := range csvColumns {
+		i := qr.columns[name]
+		if i >= len(record) {
+			line, _ := qr.r.FieldPos(0)
+			return nil, &rowError{line, fmt.Errorf("missing %s column", name)}
+		}
+		if name == device.ParamHostname {
+			params[name] = record[i]
+			continue
+		}
+		t, err := qr.options.parseTime(record[i])
+		if err != nil {
+			line, col := qr.r.FieldPos(i)
+			return nil, &rowError{line, fmt.Errorf("failed to parse %s (column %d): %w", name, col, err)}
+		}
+		params[name] = t
 	}
 
-	// Parse StartTime.
-	start, err := time.Parse(csvTimeFormat, record[1])
-	if err != nil {
-		line, col := r.FieldPos(1)
-		return nil, fmt.Errorf("failed to parse StartTime (line %d, column %d): %w", line, col, err)
+	for i, name := range qr.header {
+		if _, ok := params[name]; !ok && i < len(record) {
+			params[name] = record[i]
+		}
 	}
 
-	// Parse EndTime.
-	end, err := time.Parse(csvTimeFormat, record[2])
-	if err != nil {
-		line, col := r.FieldPos(2)
-		return nil, fmt.Errorf("failed to parse EndTime (line %d, column %d): %w", line, col, err)
+	return params, nil
+}
+
+// MapColumns maps the columns by the first record if it is a header row, or by position otherwise.
+func (qr *csvQueryReader) mapColumns(record []string) error {
+	qr.columns = map[string]int{}
+
+	for _, field := range record {
+		if strings.EqualFold(strings.TrimSpace(field), device.ParamHostname) {
+			qr.header = make([]string, len(record))
+			break
+		}
 	}
 
-	query := &device.MinMaxCPUQuery{
-		Hostname:  record[0],
-		StartTime: start,
-		EndTime:   end,
+	if qr.header == nil {
+		for i, name := range csvColumns {
+			qr.columns[name] = i
+		}
+		return nil
 	}
 
-	return query, nil
+	for i, field := range record {
+		name := strings.TrimSpace(field)
+		for _, column := range csvColumns {
+			if strings.EqualFold(name, column) {
+				name = column
+			}
+		}
+		qr.header[i] = name
+		if _, ok := qr.columns[name]; ok {
+			return fmt.Errorf("CSV header has more than one %s column", name)
+		}
+		qr.columns[name] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := qr.columns[name]; !ok {
+			return fmt.Errorf("CSV header is missing the %s column", name)
+		}
+	}
+	return nil
 }
@@ -2,10 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/sbward/ts-query-workers/device"
 )
 
@@ -16,7 +23,7 @@ var csvSampleData string
 const csvSampleDataRecords = 200
 
 func TestCSVParser(t *testing.T) {
-	queries, err := queriesFromCSV(csv.NewReader(bytes.NewBufferString(csvSampleData)))
+	queries, err := queriesFromCSV(csv.NewReader(bytes.NewBufferString(csvSampleData)), device.MinMaxCPUTemplate)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -24,10 +31,158 @@ func TestCSVParser(t *testing.T) {
 		t.Errorf("expected %d records to be parsed, but got %d", csvSampleDataRecords, len(queries))
 	}
 	for i, query := range queries {
-		_, err = device.ParseHostID(query.Hostname)
+		_, err = device.ParseHostID(query.Host())
 		if err != nil {
 			t.Errorf("query %d failed hostname check: %s", i, err)
 		}
-		t.Log(query.Hostname, query.StartTime, query.EndTime)
+		t.Log(query)
+	}
+}
+
+func TestCSVParserHeader(t *testing.T) {
+	data := "bucket_size, End_Time ,hostname,start_time\n" +
+		"5m,2017-01-01 09:59:22,host_000008,2017-01-01 08:59:22\n"
+
+	queries, err := queriesFromCSV(csv.NewReader(strings.NewReader(data)), device.MinMaxCPUTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("expected 1 query but got %d", len(queries))
+	}
+	q, ok := queries[0].(*device.MinMaxCPUQuery)
+	if !ok || q.Host() != "host_000008" || q.BucketSize != "5m" {
+		t.Fatalf("expected a min_max query of host_000008 with a 5m bucket size but got %#v", queries[0])
+	}
+	if start, end := q.TimeRange(); end.Sub(start) != time.Hour {
+		t.Errorf("expected a time range of an hour but got %s to %s", start, end)
+	}
+
+	_, err = queriesFromCSV(csv.NewReader(strings.NewReader("hostname,start_time\n")), device.MinMaxCPUTemplate)
+	if err == nil || !strings.Contains(err.Error(), "missing the end_time column") {
+		t.Errorf("expected an error for a header without an end_time column but got %v", err)
+	}
+}
+
+func TestCSVParserTimeLayouts(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2017, 1, 1, 8, 59, 22, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		options CSVOptions
+		row     string
+		start   time.Time
+	}{
+		{"default", CSVOptions{}, "2017-01-01 08:59:22,2017-01-01 09:59:22", start},
+		{"rfc3339", CSVOptions{TimeLayouts: []string{LayoutRFC3339}}, "2017-01-01T08:59:22Z,2017-01-01T09:59:22Z", start},
+		{"unix", CSVOptions{TimeLayouts: []string{LayoutUnix}}, "1483261162,1483264762", start},
+		{"unix_ms", CSVOptions{TimeLayouts: []string{LayoutUnixMillis}}, "1483261162000,1483264762000", start},
+		{"fallback", CSVOptions{TimeLayouts: []string{LayoutUnix, LayoutRFC3339}}, "2017-01-01T08:59:22Z,1483264762", start},
+		{"timezone", CSVOptions{Location: newYork}, "2017-01-01 03:59:22,2017-01-01 04:59:22", start},
+		{"offset", CSVOptions{TimeLayouts: []string{LayoutRFC3339}, Location: newYork}, "2017-01-01T08:59:22Z,2017-01-01T09:59:22Z", start},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := csv.NewReader(strings.NewReader("host_000001," + c.row + "\n"))
+			queries, err := readQueries(newCSVQueries(r, c.options, device.MinMaxCPUTemplate, defaultBucketSize("1m")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start, end := queries[0].TimeRange(); !start.Equal(c.start) || end.Sub(start) != time.Hour {
+				t.Errorf("expected a time range of an hour from %s but got %s to %s", c.start, start, end)
+			}
+		})
+	}
+}
+
+const testBadCSVData = `hostname,start_time,end_time
+host_000001,2017-01-01 08:59:22,2017-01-01 09:59:22
+host_000002,2017-01-01 08:59:22
+host_000003,yesterday,2017-01-01 09:59:22
+host_000004,2017-01-01 08:59:22,2017-01-01 09:59:22,"unterminated
+`
+
+func TestCSVParserErrors(t *testing.T) {
+	cases := []struct {
+		data string
+		err  string
+	}{
+		{"host_000001,2017-01-01 08:59:22\n", "line 1: missing end_time column"},
+		{"host_000001\n", "line 1: missing start_time column"},
+		{"host_000001,yesterday,2017-01-01 09:59:22\n", "line 1: failed to parse start_time (column 13)"},
+		{"hostname,start_time,end_time\nhost_000001,2017-01-01 08:59:22,2017-01-01 09:59:22,\"x\n", "line 2:"},
+	}
+	for _, c := range cases {
+		_, err := queriesFromCSV(csv.NewReader(strings.NewReader(c.data)), device.MinMaxCPUTemplate)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected an error containing %q for %q but got %v", c.err, c.data, err)
+		}
+	}
+}
+
+func TestCSVParserLenient(t *testing.T) {
+	reader := newCSVQueries(csv.NewReader(strings.NewReader(testBadCSVData)), CSVOptions{Lenient: true}, device.MinMaxCPUTemplate, defaultBucketSize("1m"))
+	queries, err := readQueries(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0].Host() != "host_000001" {
+		t.Fatalf("expected only the query of host_000001 but got %v", queries)
+	}
+
+	skipped := newSkippedReport(reader.skipped())
+	if skipped == nil || skipped.Rows != 3 {
+		t.Fatalf("expected 3 skipped rows but got %+v", skipped)
+	}
+	for i, line := range []string{"line 3:", "line 4:", "line 5:"} {
+		if !strings.HasPrefix(skipped.Errors[i], line) {
+			t.Errorf("expected skipped row %d to start with %q but got %q", i, line, skipped.Errors[i])
+		}
+	}
+}
+
+func TestBenchmarkCommandLenient(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("failed to init sqlmock:", err)
+		}
+		expectExplain(mock, 1)
+
+		path := filepath.Join(t.TempDir(), "queries.csv")
+		if err := os.WriteFile(path, []byte(testBadCSVData), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out, log bytes.Buffer
+		cmd := &BenchmarkCommand{CSV: f, Stream: stream, CSVOptions: CSVOptions{Lenient: true}, DB: db, Concurrency: 2,
+			Reporter: JSONReporter{}, Output: &out, Log: &log}
+		if err := cmd.Exec(context.Background()); err != nil {
+			t.Fatal("benchmark failed:", err)
+		}
+		if !strings.Contains(log.String(), "Skipped 3 rows that could not be read:\n  line 3: missing end_time column") {
+			t.Errorf("expected the skipped rows to be logged (stream %t) but got:\n%s", stream, log.String())
+		}
+
+		var report struct {
+			Skipped jsonSkipped `json:"skipped"`
+		}
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Skipped.Rows != 3 || len(report.Skipped.Errors) != 3 {
+			t.Errorf("expected 3 skipped rows in the report (stream %t) but got %+v", stream, report.Skipped)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
 	}
 }
@@ -0,0 +1,169 @@
+package device
+
+import (
+	"strings"
+	"time"
+)
+
+// PlanNode is a node of the plan tree reported by EXPLAIN with JSON formatting.
+// Actual times, rows and buffers are only reported by EXPLAIN ANALYZE with the BUFFERS option.
+type PlanNode struct {
+	NodeType           string  `json:"Node Type"`
+	CustomPlanProvider string  `json:"Custom Plan Provider"`
+	ParallelAware      bool    `json:"Parallel Aware"`
+	RelationName       string  `json:"Relation Name"`
+	Alias              string  `json:"Alias"`
+	StartupCost        float32 `json:"Startup Cost"`
+	TotalCost          float32 `json:"Total Cost"`
+	PlanRows           int     `json:"Plan Rows"`
+	PlanWidth          int     `json:"Plan Width"`
+	ActualStartupTime  float32 `json:"Actual Startup Time"`
+	ActualTotalTime    float32 `json:"Actual Total Time"`
+	ActualRows         int     `json:"Actual Rows"`
+	ActualLoops        int     `json:"Actual Loops"`
+	SharedHitBlocks    int     `json:"Shared Hit Blocks"`
+	SharedReadBlocks   int     `json:"Shared Read Blocks"`
+
+	// Chunks excluded by a TimescaleDB ChunkAppend node when the executor started, and while it ran.
+	ChunksExcludedDuringStartup int `json:"Chunks excluded during startup"`
+	ChunksExcludedDuringRuntime int `json:"Chunks excluded during runtime"`
+
+	Plans []PlanNode
+}
+
+// Type returns the node type, including the provider of a custom scan, e.g. "Custom Scan (ChunkAppend)".
+func (n *PlanNode) Type() string {
+	if n.CustomPlanProvider != "" {
+		return n.NodeType + " (" + n.CustomPlanProvider + ")"
+	}
+	return n.NodeType
+}
+
+// Walk calls fn for the node and each of its descendants, parents before their children.
+func (n *PlanNode) Walk(fn func(*PlanNode)) {
+	fn(n)
+	for i := range n.Plans {
+		n.Plans[i].Walk(fn)
+	}
+}
+
+// TotalTime returns the time spent in the node and its children across all loops.
+func (n *PlanNode) TotalTime() time.Duration {
+	return time.Duration(float64(n.ActualTotalTime) * float64(n.loops()) * float64(time.Millisecond))
+}
+
+func (n *PlanNode) loops() int {
+	if n.ActualLoops == 0 {
+		return 1
+	}
+	return n.ActualLoops
+}
+
+// NodeStats summarize the work of plan nodes, excluding the work of their children.
+type NodeStats struct {
+	// Nodes is the number of plan nodes summarized.
+	Nodes int
+
+	// Time is the time spent in the nodes across all loops.
+	Time time.Duration
+
+	// PlanRows and ActualRows are the rows the planner estimated and the nodes returned across all loops.
+	PlanRows   int
+	ActualRows int
+
+	// SharedHitBlocks and SharedReadBlocks are the shared buffer blocks found in the cache and read from disk.
+	SharedHitBlocks  int
+	SharedReadBlocks int
+}
+
+// Add adds the work of other nodes.
+func (s *NodeStats) Add(other NodeStats) {
+	s.Nodes += other.Nodes
+	s.Time += other.Time
+	s.PlanRows += other.PlanRows
+	s.ActualRows += other.ActualRows
+	s.SharedHitBlocks += other.SharedHitBlocks
+	s.SharedReadBlocks += other.SharedReadBlocks
+}
+
+// Self returns the work of the node excluding its children. EXPLAIN reports the time and buffers of a node
+// including its children, so theirs are subtracted.
+func (n *PlanNode) Self() NodeStats {
+	s := NodeStats{
+		Nodes:            1,
+		Time:             n.TotalTime(),
+		PlanRows:         n.PlanRows * n.loops(),
+		ActualRows:       n.ActualRows * n.loops(),
+		SharedHitBlocks:  n.SharedHitBlocks,
+		SharedReadBlocks: n.SharedReadBlocks,
+	}
+	for i := range n.Plans {
+		child := &n.Plans[i]
+		s.Time -= child.TotalTime()
+		s.SharedHitBlocks -= child.SharedHitBlocks
+		s.SharedReadBlocks -= child.SharedReadBlocks
+	}
+	// Parallel workers can make the children's time exceed the parent's.
+	if s.Time < 0 {
+		s.Time = 0
+	}
+	return s
+}
+
+// Breakdown returns the work of the plan tree grouped by node type.
+func (n *PlanNode) Breakdown() map[string]NodeStats {
+	breakdown := map[string]NodeStats{}
+	n.Walk(func(node *PlanNode) {
+		s := breakdown[node.Type()]
+		s.Add(node.Self())
+		breakdown[node.Type()] = s
+	})
+	return breakdown
+}
+
+// Chunks returns the names of the distinct TimescaleDB chunks scanned by the plan, in the order they appear.
+// Chunks are usually scanned below an Append, MergeAppend or Custom Scan (ChunkAppend) node,
+// or directly when only one chunk was left after exclusion.
+func (n *PlanNode) Chunks() []string {
+	var chunks []string
+	seen := map[string]bool{}
+	n.Walk(func(node *PlanNode) {
+		if IsChunk(node.RelationName) && !seen[node.RelationName] {
+			seen[node.RelationName] = true
+			chunks = append(chunks, node.RelationName)
+		}
+	})
+	return chunks
+}
+
+// ChunksExcluded returns the number of chunks that ChunkAppend nodes excluded during startup or runtime.
+// Chunks excluded by the planner don't appear in the plan at all.
+func (n *PlanNode) ChunksExcluded() int {
+	excluded := 0
+	n.Walk(func(node *PlanNode) {
+		excluded += node.ChunksExcludedDuringStartup + node.ChunksExcludedDuringRuntime
+	})
+	return excluded
+}
+
+// PlanSummary is the work of a plan by node type and the chunks it scanned, which is all that is reported of
+// each query, without holding on to the plan tree.
+type PlanSummary struct {
+	Nodes          map[string]NodeStats
+	Chunks         int
+	ChunksExcluded int
+}
+
+// Summary returns the PlanSummary of the plan tree.
+func (n *PlanNode) Summary() *PlanSummary {
+	return &PlanSummary{
+		Nodes:          n.Breakdown(),
+		Chunks:         len(n.Chunks()),
+		ChunksExcluded: n.ChunksExcluded(),
+	}
+}
+
+// IsChunk returns whether a relation is a chunk of a hypertable, named like "_hyper_1_2_chunk".
+func IsChunk(relation string) bool {
+	return strings.HasPrefix(relation, "_hyper_") && strings.HasSuffix(relation, "_chunk")
+}
@@ -0,0 +1,77 @@
+package device
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPlan is a plan of a query on a hypertable, with a sort above an append of two chunk scans.
+const testPlan = `{
+	"Node Type": "Sort", "Actual Total Time": 10, "Actual Loops": 1, "Plan Rows": 60, "Actual Rows": 60,
+	"Shared Hit Blocks": 40, "Shared Read Blocks": 8,
+	"Plans": [{
+		"Node Type": "Custom Scan", "Custom Plan Provider": "ChunkAppend", "Actual Total Time": 8, "Actual Loops": 1,
+		"Chunks excluded during startup": 1, "Chunks excluded during runtime": 0,
+		"Plan Rows": 100, "Actual Rows": 120, "Shared Hit Blocks": 40, "Shared Read Blocks": 8,
+		"Plans": [
+			{"Node Type": "Index Scan", "Relation Name": "_hyper_1_1_chunk", "Actual Total Time": 2, "Actual Loops": 2,
+				"Plan Rows": 25, "Actual Rows": 30, "Shared Hit Blocks": 30, "Shared Read Blocks": 0},
+			{"Node Type": "Index Scan", "Relation Name": "_hyper_1_2_chunk", "Actual Total Time": 3, "Actual Loops": 1,
+				"Plan Rows": 50, "Actual Rows": 60, "Shared Hit Blocks": 6, "Shared Read Blocks": 8}
+		]
+	}]
+}`
+
+func TestPlanNodeBreakdown(t *testing.T) {
+	var plan PlanNode
+	if err := json.Unmarshal([]byte(testPlan), &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if total := plan.TotalTime(); total != 10*time.Millisecond {
+		t.Errorf("expected total time 10ms but got %s", total)
+	}
+
+	expect := map[string]NodeStats{
+		"Sort":                      {Nodes: 1, Time: 2 * time.Millisecond, PlanRows: 60, ActualRows: 60},
+		"Custom Scan (ChunkAppend)": {Nodes: 1, Time: time.Millisecond, PlanRows: 100, ActualRows: 120, SharedHitBlocks: 4},
+		"Index Scan":                {Nodes: 2, Time: 7 * time.Millisecond, PlanRows: 100, ActualRows: 120, SharedHitBlocks: 36, SharedReadBlocks: 8},
+	}
+
+	breakdown := plan.Breakdown()
+	if len(breakdown) != len(expect) {
+		t.Errorf("expected %d node types but got %v", len(expect), breakdown)
+	}
+	for nodeType, want := range expect {
+		if got := breakdown[nodeType]; got != want {
+			t.Errorf("expected %s to be %+v but got %+v", nodeType, want, got)
+		}
+	}
+}
+
+func TestPlanNodeChunks(t *testing.T) {
+	var plan PlanNode
+	if err := json.Unmarshal([]byte(testPlan), &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if chunks := strings.Join(plan.Chunks(), ","); chunks != "_hyper_1_1_chunk,_hyper_1_2_chunk" {
+		t.Errorf("expected 2 chunks but got %s", chunks)
+	}
+	if excluded := plan.ChunksExcluded(); excluded != 1 {
+		t.Errorf("expected 1 chunk excluded during startup but got %d", excluded)
+	}
+	if s := plan.Summary(); s.Chunks != len(plan.Chunks()) || s.ChunksExcluded != 1 || len(s.Nodes) != len(plan.Breakdown()) {
+		t.Errorf("expected the summary to match the plan but got %+v", s)
+	}
+
+	direct := PlanNode{NodeType: "Index Scan", RelationName: "_hyper_1_3_chunk"}
+	if chunks := direct.Chunks(); len(chunks) != 1 {
+		t.Errorf("expected a chunk scanned without an append node but got %v", chunks)
+	}
+	if IsChunk("cpu_usage") {
+		t.Error("expected the hypertable not to be a chunk")
+	}
+}
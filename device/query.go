@@ -25,6 +25,8 @@ type MinMaxCPUQuery struct {
 	EndTime    time.Time
 }
 
+var _ Query = MinMaxCPUQuery{}
+
 // MinMaxBucket is a record of min and max CPU usage during a time bucket.
 type MinMaxBucket struct {
 	Bucket time.Time
@@ -36,33 +38,76 @@ type MinMaxBucket struct {
 type QueryStats struct {
 	ExecutionTime time.Duration
 	Cost          float32
+
+	// PlanningTime is the time the server spent planning the query, which is not part of the ExecutionTime.
+	PlanningTime time.Duration
+
+	// ServerExecutionTime is the top-level execution time reported by EXPLAIN ANALYZE, which also includes executor
+	// startup and shutdown and triggers, unlike the ExecutionTime of the root plan node.
+	ServerExecutionTime time.Duration
+
+	// RoundTrip is the time the client spent executing the query and receiving every row, measured by ExecCtx.
+	RoundTrip time.Duration
+
+	// Rows is the number of rows received by ExecCtx, and Bytes is the size of their values.
+	Rows  int
+	Bytes int64
+
+	// Plan is the plan tree reported by EXPLAIN, or nil if the query wasn't explained.
+	Plan *PlanNode
+
+	// Settings are the configuration parameters that affected the plan and differ from their defaults.
+	Settings map[string]string
 }
 
-// ExecCtx executes the query with the provided QuerierCtx.
-// Unless StatsOnly is true, returns a time series of one-minute MinMaxBuckets for every minute in the time range.
-// Also returns QueryStats for the performance of the query.
-func (q MinMaxCPUQuery) ExecCtx(ctx context.Context, tx QuerierCtx) ([]MinMaxBucket, QueryStats, error) {
-	// Execute the SQL query while measuring execution time.
-	start := time.Now()
-	rows, err := tx.QueryContext(ctx, minMaxCPUQuerySQL, q.BucketSize, q.Hostname, q.StartTime, q.EndTime)
-	stats := QueryStats{
-		ExecutionTime: time.Since(start),
+// Overhead estimates the time of the RoundTrip not spent on the server from two executions of the query, clamped at zero.
+func (s *QueryStats) Overhead() time.Duration {
+	server := s.ServerExecutionTime
+	if server == 0 {
+		server = s.ExecutionTime
 	}
-	if err != nil {
-		return nil, stats, err
+	if overhead := s.RoundTrip - s.PlanningTime - server; overhead > 0 {
+		return overhead
 	}
-	defer rows.Close()
+	return 0
+}
+
+// pqPlanResult is the result of an EXPLAIN ANALYZE operation with JSON formatting.
+type pqPlanResult struct {
+	Plan          PlanNode
+	Settings      map[string]string
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+}
+
+func (q MinMaxCPUQuery) ExplainAnalyze(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return explainAnalyze(ctx, tx, q)
+}
+
+func (q MinMaxCPUQuery) Explain(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return explain(ctx, tx, q)
+}
+
+func (q MinMaxCPUQuery) ExecCtx(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return exec(ctx, tx, q, nil)
+}
 
+// ExecBuckets executes the query like ExecCtx, and returns the time series of MinMaxBuckets in the time range.
+// The bytes of the rows aren't counted in the QueryStats.
+func (q MinMaxCPUQuery) ExecBuckets(ctx context.Context, tx QuerierCtx) ([]MinMaxBucket, *QueryStats, error) {
 	// Allocate a result slice with capacity for a full result set.
 	result := make([]MinMaxBucket, 0, q.maxBuckets())
 
-	// Scan each row into a MinMaxBucket.
-	for rows.Next() {
+	stats, err := exec(ctx, tx, q, func(rows *sql.Rows) error {
 		var bucket MinMaxBucket
 		if err := rows.Scan(&bucket.Bucket, &bucket.Min, &bucket.Max); err != nil {
-			return nil, stats, err
+			return err
 		}
 		result = append(result, bucket)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 	return result, stats, nil
 }
@@ -72,33 +117,87 @@ func (q MinMaxCPUQuery) maxBuckets() int {
 	return int(q.EndTime.Sub(q.StartTime) / time.Minute)
 }
 
-// pqPlanResult is the result of an EXPLAIN ANALYZE operation with JSON formatting.
-type pqPlanResult struct {
-	Plan pqPlanNode
+func (q MinMaxCPUQuery) Template() string {
+	return MinMaxCPUTemplate.Name()
 }
 
-type pqPlanNode struct {
-	NodeType          string  `json:"Node Type"`
-	ParallelAware     bool    `json:"Parallel Aware"`
-	RelationName      string  `json:"Relation Name"`
-	Alias             string  `json:"Alias"`
-	StartupCost       float32 `json:"Startup Cost"`
-	TotalCost         float32 `json:"Total Cost"`
-	PlanRows          int     `json:"Plan Rows"`
-	PlanWidth         int     `json:"Plan Width"`
-	ActualStartupTime float32 `json:"Actual Startup Time"`
-	ActualTotalTime   float32 `json:"Actual Total Time"`
-	ActualRows        int     `json:"Actual Rows"`
-	ActualLoops       int     `json:"Actual Loops"`
-	Plans             []pqPlanNode
+func (q MinMaxCPUQuery) SQL() string {
+	return minMaxCPUQuerySQL
 }
 
-func (q MinMaxCPUQuery) ExplainAnalyze(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
-	query := "EXPLAIN (ANALYZE, FORMAT JSON) " + minMaxCPUQuerySQL
-	rows, err := tx.QueryContext(ctx, query, q.BucketSize, q.Hostname, q.StartTime, q.EndTime)
+func (q MinMaxCPUQuery) Args() []any {
+	return []any{q.BucketSize, q.Hostname, q.StartTime, q.EndTime}
+}
+
+func (q MinMaxCPUQuery) Host() string {
+	return q.Hostname
+}
+
+func (q MinMaxCPUQuery) TimeRange() (start, end time.Time) {
+	return q.StartTime, q.EndTime
+}
+
+func (q MinMaxCPUQuery) String() string {
+	return fmt.Sprintf("%s %s %s", q.Hostname, q.StartTime, q.EndTime)
+}
+
+// MinMaxCPUTemplate binds params to a MinMaxCPUQuery. The bucket size defaults to "1m".
+var MinMaxCPUTemplate Template = minMaxCPUTemplate{}
+
+type minMaxCPUTemplate struct{}
+
+func (minMaxCPUTemplate) Name() string {
+	return "min_max"
+}
+
+func (minMaxCPUTemplate) Bind(params Params) (Query, error) {
+	q := &MinMaxCPUQuery{BucketSize: "1m"}
+
+	if _, ok := params[ParamBucketSize]; ok {
+		size, err := convertParam(TypeInterval, params[ParamBucketSize])
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %w", ParamBucketSize, err)
+		}
+		q.BucketSize = size.(string)
+	}
+
+	var err error
+	if q.Hostname, err = stringParam(params, ParamHostname); err != nil {
+		return nil, err
+	}
+	if q.StartTime, err = timeParam(params, ParamStartTime); err != nil {
+		return nil, err
+	}
+	if q.EndTime, err = timeParam(params, ParamEndTime); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{ParamHostname, ParamStartTime, ParamEndTime} {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("template min_max: missing param %s", name)
+		}
+	}
+
+	return q, nil
+}
+
+// ExplainAnalyze executes a Query with EXPLAIN ANALYZE and parses the server-side QueryStats from the JSON plan.
+func explainAnalyze(ctx context.Context, tx QuerierCtx, q Query) (*QueryStats, error) {
+	return explainPlan(ctx, tx, "EXPLAIN (ANALYZE, BUFFERS, TIMING, SETTINGS, FORMAT JSON) "+q.SQL(), q.Args())
+}
+
+// Explain plans a Query with EXPLAIN without executing it, and parses the estimated cost from the JSON plan.
+func explain(ctx context.Context, tx QuerierCtx, q Query) (*QueryStats, error) {
+	return explainPlan(ctx, tx, "EXPLAIN (SETTINGS, FORMAT JSON) "+q.SQL(), q.Args())
+}
+
+// ExplainPlan executes an EXPLAIN statement and parses QueryStats from the JSON plan.
+func explainPlan(ctx context.Context, tx QuerierCtx, query string, args []any) (*QueryStats, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
 	}
+	defer rows.Close()
 	result := []pqPlanResult{}
 	for rows.Next() {
 		var rawPlanJSON string
@@ -106,19 +205,78 @@ func (q MinMaxCPUQuery) ExplainAnalyze(ctx context.Context, tx QuerierCtx) (*Que
 			return nil, fmt.Errorf("scan: %w", err)
 		}
 		if err := json.Unmarshal([]byte(rawPlanJSON), &result); err != nil {
-			return nil, fmt.Errorf("parse plan json: %w", err)
+			return nil, &PlanError{fmt.Errorf("parse plan json: %w", err)}
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
 	if len(result) != 1 {
-		return nil, fmt.Errorf("expected 1 plan result but got %d", len(result))
+		return nil, &PlanError{fmt.Errorf("expected 1 plan result but got %d", len(result))}
 	}
 	stats := &QueryStats{
-		ExecutionTime: time.Duration(result[0].Plan.ActualTotalTime * float32(time.Millisecond)),
-		Cost:          result[0].Plan.TotalCost,
+		ExecutionTime:       result[0].Plan.TotalTime(),
+		Cost:                result[0].Plan.TotalCost,
+		PlanningTime:        time.Duration(result[0].PlanningTime * float64(time.Millisecond)),
+		ServerExecutionTime: time.Duration(result[0].ExecutionTime * float64(time.Millisecond)),
+		Plan:                &result[0].Plan,
+		Settings:            result[0].Settings,
 	}
 	return stats, nil
 }
 
-func (q MinMaxCPUQuery) String() string {
-	return fmt.Sprintf("%s %s %s", q.Hostname, q.StartTime, q.EndTime)
+// PlanError is returned when the output of EXPLAIN cannot be read as a query plan.
+type PlanError struct {
+	Err error
+}
+
+func (e *PlanError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PlanError) Unwrap() error {
+	return e.Err
+}
+
+// Exec executes a Query and scans every resulting row, measuring the round trip and the rows and bytes received.
+// If scan is not nil, it scans each row instead, and the bytes aren't counted.
+func exec(ctx context.Context, tx QuerierCtx, q Query, scan func(*sql.Rows) error) (*QueryStats, error) {
+	start := time.Now()
+	rows, err := tx.QueryContext(ctx, q.SQL(), q.Args()...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns: %w", err)
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	stats := &QueryStats{}
+	for rows.Next() {
+		stats.Rows++
+		if scan != nil {
+			if err := scan(rows); err != nil {
+				return nil, fmt.Errorf("scan: %w", err)
+			}
+			continue
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		for _, v := range values {
+			stats.Bytes += int64(len(v))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows: %w", err)
+	}
+	stats.RoundTrip = time.Since(start)
+	return stats, nil
 }
@@ -37,7 +37,7 @@ func TestMinMaxCPUQuery(t *testing.T) {
 				AddRow(expect[0].Bucket, expect[0].Min, expect[0].Max),
 		)
 
-	results, _, err := q.ExecCtx(context.Background(), db)
+	results, _, err := q.ExecBuckets(context.Background(), db)
 	if err != nil {
 		t.Fatal("failed to execute query:", err)
 	}
@@ -55,3 +55,46 @@ func TestMinMaxCPUQuery(t *testing.T) {
 		t.Errorf("expected max %0.1f but got %0.1f", expect[0].Max, max)
 	}
 }
+
+func TestMinMaxCPUQueryExecCtx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	q := &MinMaxCPUQuery{BucketSize: "1m", Hostname: "host-001", StartTime: time.Now(), EndTime: time.Now().Add(time.Hour)}
+
+	mock.ExpectQuery(regexp.QuoteMeta(minMaxCPUQuerySQL)).
+		WithArgs("1m", q.Hostname, q.StartTime, q.EndTime).
+		WillReturnRows(sqlmock.NewRows([]string{"time", "min_usage", "max_usage"}).AddRow("2017-01-01 08:00:00", "0.1", "0.9"))
+
+	stats, err := q.ExecCtx(context.Background(), db)
+	if err != nil {
+		t.Fatal("failed to execute query:", err)
+	}
+	if stats.Rows != 1 || stats.Bytes != 25 || stats.RoundTrip <= 0 {
+		t.Errorf("expected 1 row of 25 bytes and a round trip but got %+v", stats)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryStatsOverhead(t *testing.T) {
+	s := QueryStats{RoundTrip: 10 * time.Millisecond, PlanningTime: time.Millisecond, ExecutionTime: 4 * time.Millisecond}
+	if overhead := s.Overhead(); overhead != 5*time.Millisecond {
+		t.Errorf("expected an overhead of 5ms but got %s", overhead)
+	}
+
+	// The top-level execution time is preferred to the execution time of the root node.
+	s.ServerExecutionTime = 6 * time.Millisecond
+	if overhead := s.Overhead(); overhead != 3*time.Millisecond {
+		t.Errorf("expected an overhead of 3ms but got %s", overhead)
+	}
+
+	// A round trip faster than the server-side time of an earlier execution has no overhead.
+	s.RoundTrip = 3 * time.Millisecond
+	if overhead := s.Overhead(); overhead != 0 {
+		t.Errorf("expected the overhead to be clamped at zero but got %s", overhead)
+	}
+}
@@ -0,0 +1,371 @@
+package device
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conventional parameter names, bound from the columns of a query specification.
+const (
+	ParamHostname   = "hostname"
+	ParamStartTime  = "start_time"
+	ParamEndTime    = "end_time"
+	ParamBucketSize = "bucket_size"
+)
+
+// TimeLayouts are the layouts accepted when a timestamp parameter is provided as a string.
+var TimeLayouts = []string{"2006-01-02 15:04:05", time.RFC3339Nano}
+
+// Query is a parameterized SQL query against the cpu_usage hypertable which can be benchmarked.
+type Query interface {
+	fmt.Stringer
+
+	// Template returns the name of the template the query was built from.
+	Template() string
+
+	// SQL returns the SQL text with positional placeholders ($1, $2, ...).
+	SQL() string
+
+	// Args returns the values bound to the SQL placeholders.
+	Args() []any
+
+	// Host returns the hostname the query targets, or "" if it has none.
+	Host() string
+
+	// TimeRange returns the time range the query covers, or zero times if it has none.
+	TimeRange() (start, end time.Time)
+
+	// ExplainAnalyze executes the query with EXPLAIN ANALYZE and returns the server-side QueryStats.
+	ExplainAnalyze(ctx context.Context, tx QuerierCtx) (*QueryStats, error)
+
+	// Explain plans the query with EXPLAIN without executing it, and returns QueryStats with only the estimated cost.
+	Explain(ctx context.Context, tx QuerierCtx) (*QueryStats, error)
+
+	// ExecCtx executes the query, scans and discards the resulting rows, and returns QueryStats measured by the client.
+	ExecCtx(ctx context.Context, tx QuerierCtx) (*QueryStats, error)
+}
+
+// Params are named values which are bound to a Template to build a Query.
+// Values may be strings, which are parsed according to the type of the placeholder they are bound to.
+type Params map[string]any
+
+// Template builds Queries by binding Params.
+type Template interface {
+	// Name identifies the template, e.g. "min_max".
+	Name() string
+
+	// Bind returns a Query with the params bound to the template placeholders.
+	// Params which the template does not use are ignored.
+	Bind(params Params) (Query, error)
+}
+
+//go:embed templates/*.sql
+var templateFiles embed.FS
+
+// LookupTemplate returns the built-in template with the given name.
+func LookupTemplate(name string) (Template, error) {
+	if name == MinMaxCPUTemplate.Name() {
+		return MinMaxCPUTemplate, nil
+	}
+	text, err := templateFiles.ReadFile("templates/" + name + ".sql")
+	if err != nil {
+		return nil, fmt.Errorf("unknown query template %q (expected one of: %s)", name, strings.Join(TemplateNames(), ", "))
+	}
+	return ParseTemplate(name, string(text))
+}
+
+// TemplateNames returns the names of the built-in templates.
+func TemplateNames() []string {
+	names := []string{MinMaxCPUTemplate.Name()}
+	entries, _ := templateFiles.ReadDir("templates")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".sql"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTemplate parses a SQL template file. The template is named after the file, without its extension.
+func LoadTemplate(path string) (*SQLTemplate, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), string(text))
+}
+
+// PlaceholderType is the SQL type of a template placeholder.
+type PlaceholderType string
+
+const (
+	TypeText        PlaceholderType = "text"
+	TypeInt         PlaceholderType = "int"
+	TypeFloat       PlaceholderType = "float"
+	TypeBool        PlaceholderType = "bool"
+	TypeTimestamptz PlaceholderType = "timestamptz"
+	TypeInterval    PlaceholderType = "interval"
+)
+
+// Cast is the Postgres type cast appended to the positional placeholder.
+var placeholderCasts = map[PlaceholderType]string{
+	TypeText:        "text",
+	TypeInt:         "bigint",
+	TypeFloat:       "double precision",
+	TypeBool:        "boolean",
+	TypeTimestamptz: "timestamptz",
+	TypeInterval:    "interval",
+}
+
+// Placeholder is a typed, named parameter of a SQLTemplate.
+type Placeholder struct {
+	Name    string
+	Type    PlaceholderType
+	Default string // Used when the param is not provided, unless empty.
+}
+
+// SQLTemplate is a SQL query with typed placeholders of the form ${name:type} or ${name:type=default},
+// e.g. "WHERE host = ${hostname:text} AND ts > ${start_time:timestamptz}".
+// Each distinct name is compiled to a positional placeholder with a type cast, e.g. "$1::text".
+type SQLTemplate struct {
+	name         string
+	sql          string
+	placeholders []Placeholder
+}
+
+var _ Template = (*SQLTemplate)(nil)
+
+var placeholderPattern = regexp.MustCompile(`\$\{(\w+):(\w+)(?:=([^}]*))?\}`)
+
+// ParseTemplate compiles the text of a SQL template.
+func ParseTemplate(name, text string) (*SQLTemplate, error) {
+	t := &SQLTemplate{name: name}
+	positions := map[string]int{}
+
+	var err error
+	t.sql = placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := placeholderPattern.FindStringSubmatch(match)
+		p := Placeholder{Name: m[1], Type: PlaceholderType(m[2]), Default: m[3]}
+
+		cast, ok := placeholderCasts[p.Type]
+		if !ok && err == nil {
+			err = fmt.Errorf("template %s: placeholder %s has unknown type %q", name, p.Name, p.Type)
+		}
+
+		pos, ok := positions[p.Name]
+		if !ok {
+			t.placeholders = append(t.placeholders, p)
+			pos = len(t.placeholders)
+			positions[p.Name] = pos
+		} else if prev := t.placeholders[pos-1]; prev.Type != p.Type && err == nil {
+			err = fmt.Errorf("template %s: placeholder %s is declared as both %s and %s", name, p.Name, prev.Type, p.Type)
+		}
+
+		return fmt.Sprintf("$%d::%s", pos, cast)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *SQLTemplate) Name() string {
+	return t.name
+}
+
+// SQL returns the compiled SQL text with positional placeholders.
+func (t *SQLTemplate) SQL() string {
+	return t.sql
+}
+
+// Placeholders returns the placeholders of the template in positional order.
+func (t *SQLTemplate) Placeholders() []Placeholder {
+	return t.placeholders
+}
+
+func (t *SQLTemplate) Bind(params Params) (Query, error) {
+	q := &TemplateQuery{
+		template: t,
+		args:     make([]any, len(t.placeholders)),
+	}
+
+	for i, p := range t.placeholders {
+		value, ok := params[p.Name]
+		if !ok {
+			if p.Default == "" {
+				return nil, fmt.Errorf("template %s: missing param %s", t.name, p.Name)
+			}
+			value = p.Default
+		}
+		arg, err := convertParam(p.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: param %s: %w", t.name, p.Name, err)
+		}
+		q.args[i] = arg
+	}
+
+	// The conventional params identify the host and time range even if the template does not use them.
+	var err error
+	if q.host, err = stringParam(params, ParamHostname); err != nil {
+		return nil, err
+	}
+	if q.start, err = timeParam(params, ParamStartTime); err != nil {
+		return nil, err
+	}
+	if q.end, err = timeParam(params, ParamEndTime); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// TemplateQuery is a Query built by binding Params to a SQLTemplate.
+type TemplateQuery struct {
+	template *SQLTemplate
+	args     []any
+	host     string
+	start    time.Time
+	end      time.Time
+}
+
+var _ Query = (*TemplateQuery)(nil)
+
+func (q *TemplateQuery) Template() string {
+	return q.template.name
+}
+
+func (q *TemplateQuery) SQL() string {
+	return q.template.sql
+}
+
+func (q *TemplateQuery) Args() []any {
+	return q.args
+}
+
+func (q *TemplateQuery) Host() string {
+	return q.host
+}
+
+func (q *TemplateQuery) TimeRange() (start, end time.Time) {
+	return q.start, q.end
+}
+
+func (q *TemplateQuery) ExplainAnalyze(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return explainAnalyze(ctx, tx, q)
+}
+
+func (q *TemplateQuery) Explain(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return explain(ctx, tx, q)
+}
+
+func (q *TemplateQuery) ExecCtx(ctx context.Context, tx QuerierCtx) (*QueryStats, error) {
+	return exec(ctx, tx, q, nil)
+}
+
+func (q *TemplateQuery) String() string {
+	return fmt.Sprintf("%s %v", q.template.name, q.args)
+}
+
+// ConvertParam converts a param value to the Go type bound to a placeholder of type t.
+func convertParam(t PlaceholderType, value any) (any, error) {
+	switch t {
+	case TypeText:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+
+	case TypeInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+
+	case TypeFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+
+	case TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+
+	case TypeTimestamptz:
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			return parseTime(v)
+		}
+
+	case TypeInterval:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case time.Duration:
+			return fmt.Sprintf("%d microseconds", v.Microseconds()), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot bind %T to %s", value, t)
+}
+
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range TimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// StringParam returns a text param, or "" if it is not provided.
+func stringParam(params Params, name string) (string, error) {
+	value, ok := params[name]
+	if !ok {
+		return "", nil
+	}
+	s, err := convertParam(TypeText, value)
+	if err != nil {
+		return "", fmt.Errorf("param %s: %w", name, err)
+	}
+	return s.(string), nil
+}
+
+// TimeParam returns a timestamp param, or the zero time if it is not provided.
+func timeParam(params Params, name string) (time.Time, error) {
+	value, ok := params[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+	t, err := convertParam(TypeTimestamptz, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("param %s: %w", name, err)
+	}
+	return t.(time.Time), nil
+}
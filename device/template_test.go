@@ -0,0 +1,238 @@
+package device
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("test", "SELECT ${a:int} + ${b:float=1.5}, ${a:int}, ${ts:timestamptz}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectSQL := "SELECT $1::bigint + $2::double precision, $1::bigint, $3::timestamptz"
+	if sql := tmpl.SQL(); sql != expectSQL {
+		t.Errorf("expected SQL %q but got %q", expectSQL, sql)
+	}
+
+	q, err := tmpl.Bind(Params{"a": "7", "ts": "2017-01-01 08:59:22", "hostname": "host_000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := q.Args()
+	if len(args) != 3 {
+		t.Fatalf("expected 3 args but got %d", len(args))
+	}
+	if a := args[0]; a != int64(7) {
+		t.Errorf("expected a=7 but got %v", a)
+	}
+	if b := args[1]; b != 1.5 {
+		t.Errorf("expected default b=1.5 but got %v", b)
+	}
+	if ts := args[2].(time.Time); !ts.Equal(time.Date(2017, 1, 1, 8, 59, 22, 0, time.UTC)) {
+		t.Errorf("unexpected timestamp %s", ts)
+	}
+	if host := q.Host(); host != "host_000001" {
+		t.Errorf("expected host_000001 but got %q", host)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	if _, err := ParseTemplate("test", "SELECT ${a:uuid}"); err == nil {
+		t.Error("expected an error for an unknown placeholder type")
+	}
+	if _, err := ParseTemplate("test", "SELECT ${a:int}, ${a:text}"); err == nil {
+		t.Error("expected an error for a placeholder declared with two types")
+	}
+
+	tmpl, err := ParseTemplate("test", "SELECT ${a:int}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Bind(Params{}); err == nil {
+		t.Error("expected an error for a missing param")
+	}
+	if _, err := tmpl.Bind(Params{"a": "seven"}); err == nil {
+		t.Error("expected an error for an invalid int param")
+	}
+}
+
+func TestBuiltinTemplates(t *testing.T) {
+	params := Params{
+		ParamHostname:  "host_000001",
+		ParamStartTime: "2017-01-01 08:59:22",
+		ParamEndTime:   "2017-01-01 09:59:22",
+	}
+
+	for _, name := range TemplateNames() {
+		tmpl, err := LookupTemplate(name)
+		if err != nil {
+			t.Fatalf("template %s: %s", name, err)
+		}
+		q, err := tmpl.Bind(params)
+		if err != nil {
+			t.Fatalf("template %s: %s", name, err)
+		}
+		if q.Template() != name {
+			t.Errorf("template %s: query reports template %q", name, q.Template())
+		}
+		if start, end := q.TimeRange(); end.Sub(start) != time.Hour {
+			t.Errorf("template %s: expected a 1h time range but got %s", name, end.Sub(start))
+		}
+	}
+
+	if _, err := LookupTemplate("nope"); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
+
+func TestTemplateQueryExplainAnalyze(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	tmpl, err := LookupTemplate("last_point")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := tmpl.Bind(Params{
+		ParamHostname:  "host_000001",
+		ParamStartTime: "2017-01-01 08:59:22",
+		ParamEndTime:   "2017-01-01 09:59:22",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, BUFFERS, TIMING, SETTINGS, FORMAT JSON) "+q.SQL())).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"QUERY PLAN"}).
+				AddRow(`[{"Plan": {"Node Type": "Limit", "Total Cost": 12.5, "Actual Total Time": 1.25}}]`),
+		)
+
+	stats, err := q.ExplainAnalyze(context.Background(), db)
+	if err != nil {
+		t.Fatal("failed to explain query:", err)
+	}
+	if stats.ExecutionTime != 1250*time.Microsecond {
+		t.Errorf("expected execution time 1.25ms but got %s", stats.ExecutionTime)
+	}
+	if stats.Cost != 12.5 {
+		t.Errorf("expected cost 12.5 but got %0.1f", stats.Cost)
+	}
+}
+
+func TestExplainAnalyzePlanningTime(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	q, err := MinMaxCPUTemplate.Bind(Params{
+		ParamHostname:  "host_000001",
+		ParamStartTime: "2017-01-01 08:59:22",
+		ParamEndTime:   "2017-01-01 09:59:22",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("EXPLAIN").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"QUERY PLAN"}).
+				AddRow(`[{"Plan": {"Node Type": "Sort", "Actual Total Time": 1.25}, "Planning Time": 0.5, "Execution Time": 1.5}]`),
+		)
+
+	stats, err := q.ExplainAnalyze(context.Background(), db)
+	if err != nil {
+		t.Fatal("failed to explain query:", err)
+	}
+	if stats.PlanningTime != 500*time.Microsecond {
+		t.Errorf("expected planning time 0.5ms but got %s", stats.PlanningTime)
+	}
+	if stats.ExecutionTime != 1250*time.Microsecond {
+		t.Errorf("expected the execution time of the root node 1.25ms but got %s", stats.ExecutionTime)
+	}
+	if stats.ServerExecutionTime != 1500*time.Microsecond {
+		t.Errorf("expected the top-level execution time 1.5ms but got %s", stats.ServerExecutionTime)
+	}
+}
+
+func TestQueryExplain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	q, err := MinMaxCPUTemplate.Bind(Params{
+		ParamHostname:  "host_000001",
+		ParamStartTime: "2017-01-01 08:59:22",
+		ParamEndTime:   "2017-01-01 09:59:22",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN (SETTINGS, FORMAT JSON) " + q.SQL())).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"QUERY PLAN"}).
+				AddRow(`[{"Plan": {"Node Type": "Sort", "Total Cost": 42}}]`),
+		)
+
+	stats, err := q.Explain(context.Background(), db)
+	if err != nil {
+		t.Fatal("failed to explain query:", err)
+	}
+	if stats.Cost != 42 || stats.ExecutionTime != 0 {
+		t.Errorf("expected only the estimated cost 42 but got %+v", stats)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryExecCtx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	q, err := MinMaxCPUTemplate.Bind(Params{
+		ParamHostname:  "host_000001",
+		ParamStartTime: "2017-01-01 08:59:22",
+		ParamEndTime:   "2017-01-01 09:59:22",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(q.SQL())).
+		WillDelayFor(10 * time.Millisecond).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"time", "min_usage", "max_usage"}).
+				AddRow("2017-01-01 09:00:00", "0.25", "0.75").
+				AddRow("2017-01-01 09:01:00", "0.5", "1"),
+		)
+
+	stats, err := q.ExecCtx(context.Background(), db)
+	if err != nil {
+		t.Fatal("failed to execute query:", err)
+	}
+	if stats.Rows != 2 || stats.Bytes != 2*19+4+4+3+1 {
+		t.Errorf("expected 2 rows of 50 bytes but got %d rows of %d bytes", stats.Rows, stats.Bytes)
+	}
+	if stats.RoundTrip < 10*time.Millisecond {
+		t.Errorf("expected the round trip to include the query delay but got %s", stats.RoundTrip)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
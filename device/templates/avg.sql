@@ -0,0 +1,7 @@
+SELECT
+  time_bucket(${bucket_size:interval=1m}, ts) AS "time",
+  avg(usage) AS "avg_usage"
+FROM cpu_usage
+WHERE host = ${hostname:text} AND ts BETWEEN ${start_time:timestamptz} AND ${end_time:timestamptz}
+GROUP BY time
+ORDER BY time
\ No newline at end of file
@@ -0,0 +1,7 @@
+SELECT
+  ts AS "time",
+  usage
+FROM cpu_usage
+WHERE host = ${hostname:text} AND ts BETWEEN ${start_time:timestamptz} AND ${end_time:timestamptz}
+ORDER BY ts DESC
+LIMIT 1
\ No newline at end of file
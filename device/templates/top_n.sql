@@ -0,0 +1,8 @@
+SELECT
+  host,
+  max(usage) AS "max_usage"
+FROM cpu_usage
+WHERE ts BETWEEN ${start_time:timestamptz} AND ${end_time:timestamptz}
+GROUP BY host
+ORDER BY max_usage DESC
+LIMIT ${limit:int=5}
\ No newline at end of file
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// DefaultHostPattern is the format of generated hostnames, which matches device.ParseHostID.
+const DefaultHostPattern = "host_%06d"
+
+// GenQueriesCommand writes synthetic query specifications from a seeded random generator,
+// so a workload with a given skew and range lengths can be reproduced from its configuration.
+type GenQueriesCommand struct {
+	// Queries is the number of query specifications to write.
+	Queries int
+
+	// Seed seeds the generator. The same configuration and seed always generate the same queries.
+	Seed int64
+
+	// Hosts is the number of hosts, whose IDs are 0 to Hosts-1.
+	Hosts int
+
+	// HostPattern formats the ID of a host as its hostname. Optional, defaults to DefaultHostPattern.
+	HostPattern string
+
+	// Start and End bound the time ranges of the queries.
+	Start, End time.Time
+
+	// RangeLengths is the distribution of the lengths of time ranges: "fixed:LENGTH", "uniform:MIN-MAX" or
+	// "exponential:MEAN", e.g. "uniform:5m-6h". Lengths are capped at the window from Start to End.
+	RangeLengths string
+
+	// Zipf is the exponent of the Zipfian distribution of host popularity, which must be greater than 1,
+	// where host 0 is the most popular, host 1 the second most popular and so on. Optional, if 0 every host is
+	// equally popular.
+	Zipf float64
+
+	// BucketSizes are the bucket sizes of the queries, each chosen with a probability proportional to its
+	// weight. Optional, defaults to "1m".
+	BucketSizes []WeightedBucketSize
+
+	// Format is the format of the query specifications: "csv" or "jsonl". Optional, defaults to "csv".
+	Format string
+
+	// Output is the destination of the query specifications. Optional, defaults to stdout.
+	Output io.Writer
+}
+
+// WeightedBucketSize is a bucket size with its relative frequency among generated queries.
+type WeightedBucketSize struct {
+	Size   string
+	Weight float64
+}
+
+// NewGenQueriesCommandFromCLI reads the configuration of a GenQueriesCommand from the arguments following "gen-queries".
+func NewGenQueriesCommandFromCLI(args []string) (*GenQueriesCommand, error) {
+	flags := flag.NewFlagSet("gen-queries", flag.ExitOnError)
+	queries := flags.Int("n", 1000, "number of queries to generate")
+	seed := flags.Int64("seed", 1, "seed of the random generator")
+	hosts := flags.Int("hosts", 10, "number of hosts")
+	pattern := flags.String("host-pattern", DefaultHostPattern, "format of hostnames, given the host ID")
+	start := flags.String("start", "2017-01-01 00:00:00", "start of the time window of the queries")
+	end := flags.String("end", "2017-01-03 00:00:00", "end of the time window of the queries")
+	ranges := flags.String("range", "fixed:1h", "distribution of time range lengths: fixed:LENGTH, uniform:MIN-MAX or exponential:MEAN")
+	zipf := flags.Float64("zipf", 0, "exponent of the Zipfian host popularity, greater than 1 (defaults to equally popular hosts)")
+	buckets := flags.String("bucket-sizes", "1m", "comma-separated bucket sizes with optional weights, e.g. 1m:3,5m:1")
+	format := flags.String("format", InputCSV, "format of the query specifications: csv or jsonl")
+	output := flags.String("o", "", "write the queries to a file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ts-query-workers gen-queries [options]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cmd := &GenQueriesCommand{
+		Queries:      *queries,
+		Seed:         *seed,
+		Hosts:        *hosts,
+		HostPattern:  *pattern,
+		RangeLengths: *ranges,
+		Zipf:         *zipf,
+		Format:       *format,
+	}
+
+	var err error
+	if cmd.Start, err = time.Parse(csvTimeFormat, *start); err != nil {
+		return nil, fmt.Errorf("invalid -start: %w", err)
+	}
+	if cmd.End, err = time.Parse(csvTimeFormat, *end); err != nil {
+		return nil, fmt.Errorf("invalid -end: %w", err)
+	}
+	if cmd.BucketSizes, err = parseBucketSizes(*buckets); err != nil {
+		return nil, err
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Output = f
+	}
+
+	return cmd, nil
+}
+
+// ParseBucketSizes parses comma-separated bucket sizes, each with an optional weight after a colon.
+func parseBucketSizes(s string) ([]WeightedBucketSize, error) {
+	var sizes []WeightedBucketSize
+	for _, field := range strings.Split(s, ",") {
+		size, weight, found := strings.Cut(strings.TrimSpace(field), ":")
+		b := WeightedBucketSize{Size: size, Weight: 1}
+		if found {
+			var err error
+			if b.Weight, err = strconv.ParseFloat(weight, 64); err != nil || b.Weight <= 0 {
+				return nil, fmt.Errorf("invalid weight of bucket size %q (expected a positive number)", field)
+			}
+		}
+		if b.Size == "" {
+			return nil, fmt.Errorf("invalid bucket size %q", field)
+		}
+		sizes = append(sizes, b)
+	}
+	return sizes, nil
+}
+
+// Exec generates the queries and writes them to the Output.
+func (c *GenQueriesCommand) Exec(ctx context.Context) error {
+	if closer, ok := c.Output.(io.Closer); ok && c.Output != os.Stdout {
+		defer closer.Close()
+	}
+
+	if c.Queries < 0 {
+		return fmt.Errorf("number of queries must not be negative (received: %d)", c.Queries)
+	}
+	if c.Hosts <= 0 {
+		return fmt.Errorf("number of hosts must be greater than zero (received: %d)", c.Hosts)
+	}
+	if !c.End.After(c.Start) {
+		return errors.New("end of the time window must be after its start")
+	}
+
+	r := rand.New(rand.NewSource(c.Seed))
+
+	lengths, err := NewRangeLengths(c.RangeLengths, r)
+	if err != nil {
+		return err
+	}
+	hosts, err := c.hostIDs(r)
+	if err != nil {
+		return err
+	}
+	buckets := c.bucketSizes(r)
+
+	pattern := c.HostPattern
+	if pattern == "" {
+		pattern = DefaultHostPattern
+	}
+
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	buf := bufio.NewWriter(out)
+
+	var write func(hostname string, start, end time.Time, bucketSize string) error
+	flush := buf.Flush
+	switch c.Format {
+	case "", InputCSV:
+		w := csv.NewWriter(buf)
+		if err := w.Write([]string{device.ParamHostname, device.ParamStartTime, device.ParamEndTime, device.ParamBucketSize}); err != nil {
+			return err
+		}
+		write = func(hostname string, start, end time.Time, bucketSize string) error {
+			return w.Write([]string{hostname, start.Format(csvTimeFormat), end.Format(csvTimeFormat), bucketSize})
+		}
+		flush = func() error {
+			w.Flush()
+			if err := w.Error(); err != nil {
+				return err
+			}
+			return buf.Flush()
+		}
+	case InputJSONL:
+		enc := json.NewEncoder(buf)
+		write = func(hostname string, start, end time.Time, bucketSize string) error {
+			return enc.Encode(generatedSpec{hostname, start.Format(csvTimeFormat), end.Format(csvTimeFormat), bucketSize})
+		}
+	default:
+		return fmt.Errorf("unknown format %q (expected csv or jsonl)", c.Format)
+	}
+
+	window := c.End.Sub(c.Start)
+
+	for i := 0; i < c.Queries && ctx.Err() == nil; i++ {
+		hostname := fmt.Sprintf(pattern, hosts())
+
+		length := lengths().Truncate(time.Second)
+		if length < time.Second {
+			length = time.Second
+		}
+		if length > window {
+			length = window
+		}
+		start := c.Start.Add(time.Duration(r.Int63n(int64((window-length)/time.Second)+1)) * time.Second)
+
+		if err := write(hostname, start, start.Add(length), buckets()); err != nil {
+			return err
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// GeneratedSpec is a line of a generated JSONL file.
+type generatedSpec struct {
+	Hostname   string `json:"hostname"`
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+	BucketSize string `json:"bucket_size"`
+}
+
+// HostIDs returns a function that chooses the ID of the host of each query.
+func (c *GenQueriesCommand) hostIDs(r *rand.Rand) (func() int, error) {
+	if c.Zipf == 0 {
+		return func() int { return r.Intn(c.Hosts) }, nil
+	}
+	if c.Zipf <= 1 {
+		return nil, fmt.Errorf("zipf exponent must be greater than 1 (received: %g)", c.Zipf)
+	}
+	z := rand.NewZipf(r, c.Zipf, 1, uint64(c.Hosts-1))
+	return func() int { return int(z.Uint64()) }, nil
+}
+
+// BucketSizes returns a function that chooses the bucket size of each query by weight.
+func (c *GenQueriesCommand) bucketSizes(r *rand.Rand) func() string {
+	sizes := c.BucketSizes
+	if len(sizes) == 0 {
+		sizes = []WeightedBucketSize{{"1m", 1}}
+	}
+	total := 0.0
+	for _, b := range sizes {
+		total += b.Weight
+	}
+	return func() string {
+		x := r.Float64() * total
+		for _, b := range sizes {
+			if x < b.Weight {
+				return b.Size
+			}
+			x -= b.Weight
+		}
+		return sizes[len(sizes)-1].Size
+	}
+}
+
+// RangeLengths returns the length of the time range of the next generated query.
+type RangeLengths func() time.Duration
+
+// FixedRangeLengths returns RangeLengths that are always the same length.
+func FixedRangeLengths(length time.Duration) RangeLengths {
+	return func() time.Duration {
+		return length
+	}
+}
+
+// UniformRangeLengths returns RangeLengths uniformly distributed between min and max.
+func UniformRangeLengths(min, max time.Duration, r *rand.Rand) RangeLengths {
+	return func() time.Duration {
+		return min + time.Duration(r.Int63n(int64(max-min)+1))
+	}
+}
+
+// ExponentialRangeLengths returns exponentially distributed RangeLengths with a mean,
+// so most ranges are short but a few are long scans.
+func ExponentialRangeLengths(mean time.Duration, r *rand.Rand) RangeLengths {
+	return func() time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// NewRangeLengths returns the RangeLengths of a distribution: "fixed:LENGTH", "uniform:MIN-MAX" or "exponential:MEAN".
+func NewRangeLengths(distribution string, r *rand.Rand) (RangeLengths, error) {
+	name, params, _ := strings.Cut(distribution, ":")
+	switch name {
+	case "fixed", "exponential":
+		length, err := time.ParseDuration(params)
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid range length distribution %q (expected a positive duration, e.g. %s:1h)", distribution, name)
+		}
+		if name == "fixed" {
+			return FixedRangeLengths(length), nil
+		}
+		return ExponentialRangeLengths(length, r), nil
+	case "uniform":
+		lo, hi, _ := strings.Cut(params, "-")
+		min, errMin := time.ParseDuration(lo)
+		max, errMax := time.ParseDuration(hi)
+		if errMin != nil || errMax != nil || min <= 0 || max < min {
+			return nil, fmt.Errorf("invalid range length distribution %q (expected positive durations, e.g. uniform:5m-6h)", distribution)
+		}
+		return UniformRangeLengths(min, max, r), nil
+	}
+	return nil, fmt.Errorf("unknown range length distribution %q (expected fixed, uniform or exponential)", distribution)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func testGenQueriesCommand(out *bytes.Buffer) *GenQueriesCommand {
+	return &GenQueriesCommand{
+		Queries:      1000,
+		Seed:         42,
+		Hosts:        20,
+		Start:        time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:          time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC),
+		RangeLengths: "uniform:5m-6h",
+		Zipf:         1.5,
+		BucketSizes:  []WeightedBucketSize{{"1m", 3}, {"1h", 1}},
+		Output:       out,
+	}
+}
+
+func TestGenQueriesCommand(t *testing.T) {
+	for _, format := range []string{InputCSV, InputJSONL} {
+		var out bytes.Buffer
+		cmd := testGenQueriesCommand(&out)
+		cmd.Format = format
+		if err := cmd.Exec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		queries, err := queriesFromInput(&out, format, CSVOptions{}, device.MinMaxCPUTemplate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queries) != 1000 {
+			t.Fatalf("expected 1000 %s queries but got %d", format, len(queries))
+		}
+
+		// Every query is within the window and of a known host, so only duplicates are expected.
+		v := Validation{Hosts: cmd.Hosts, Start: cmd.Start, End: cmd.End}
+		for _, issue := range v.Validate(queries) {
+			if issue.Check != CheckDuplicate {
+				t.Errorf("expected a valid %s query but got %s", format, issue)
+			}
+		}
+
+		hosts := map[string]int{}
+		buckets := map[string]int{}
+		for _, q := range queries {
+			hosts[q.Host()]++
+			buckets[q.(*device.MinMaxCPUQuery).BucketSize]++
+			if start, end := q.TimeRange(); end.Sub(start) < 5*time.Minute || end.Sub(start) > 6*time.Hour {
+				t.Errorf("expected a range between 5m and 6h but got %s", end.Sub(start))
+			}
+		}
+		if hosts["host_000000"] < hosts["host_000001"] || hosts["host_000001"] < hosts["host_000019"] {
+			t.Errorf("expected Zipfian host popularity but got %v", hosts)
+		}
+		if buckets["1m"] < 2*buckets["1h"] || buckets["1h"] == 0 {
+			t.Errorf("expected about 3 times as many 1m buckets as 1h buckets but got %v", buckets)
+		}
+	}
+}
+
+func TestGenQueriesCommandSeed(t *testing.T) {
+	generate := func(seed int64) string {
+		var out bytes.Buffer
+		cmd := testGenQueriesCommand(&out)
+		cmd.Seed = seed
+		if err := cmd.Exec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if generate(1) != generate(1) {
+		t.Error("expected the same queries from the same seed")
+	}
+	if generate(1) == generate(2) {
+		t.Error("expected different queries from different seeds")
+	}
+}
+
+func TestGenQueriesCommandErrors(t *testing.T) {
+	for expect, modify := range map[string]func(*GenQueriesCommand){
+		"zipf exponent":            func(c *GenQueriesCommand) { c.Zipf = 0.5 },
+		"number of hosts":          func(c *GenQueriesCommand) { c.Hosts = 0 },
+		"end of the time window":   func(c *GenQueriesCommand) { c.End = c.Start },
+		"unknown range length":     func(c *GenQueriesCommand) { c.RangeLengths = "normal:1h" },
+		"invalid range length":     func(c *GenQueriesCommand) { c.RangeLengths = "uniform:6h-5m" },
+		`unknown format "parquet"`: func(c *GenQueriesCommand) { c.Format = "parquet" },
+		"must not be negative":     func(c *GenQueriesCommand) { c.Queries = -1 },
+	} {
+		cmd := testGenQueriesCommand(&bytes.Buffer{})
+		modify(cmd)
+		if err := cmd.Exec(context.Background()); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected an error containing %q but got %v", expect, err)
+		}
+	}
+}
+
+func TestParseBucketSizes(t *testing.T) {
+	sizes, err := parseBucketSizes("1m:3, 5m,1h:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []WeightedBucketSize{{"1m", 3}, {"5m", 1}, {"1h", 0.5}}
+	if len(sizes) != len(expect) {
+		t.Fatalf("expected %v but got %v", expect, sizes)
+	}
+	for i := range expect {
+		if sizes[i] != expect[i] {
+			t.Errorf("expected %v but got %v", expect[i], sizes[i])
+		}
+	}
+
+	for _, s := range []string{"1m:0", "1m:x", ":2", "1m,"} {
+		if _, err := parseBucketSizes(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestRangeLengths(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	exponential, err := NewRangeLengths("exponential:1h", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := time.Duration(0)
+	for i := 0; i < 10000; i++ {
+		total += exponential()
+	}
+	if mean := total / 10000; mean < 55*time.Minute || mean > 65*time.Minute {
+		t.Errorf("expected a mean range length of about 1h but got %s", mean)
+	}
+
+	fixed, err := NewRangeLengths("fixed:90m", r)
+	if err != nil || fixed() != 90*time.Minute {
+		t.Errorf("expected fixed range lengths of 90m but got %v", err)
+	}
+}
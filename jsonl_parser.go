@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// InputFormats are the formats of query specification files.
+const (
+	InputCSV   = "csv"
+	InputJSONL = "jsonl"
+)
+
+// InputFormat returns the format of a query specification file: the format if it is set,
+// otherwise JSONL if the filename has a .jsonl or .ndjson extension, and CSV for any other file.
+func inputFormat(format, filename string) (string, error) {
+	switch format {
+	case InputCSV, InputJSONL:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown input format %q (expected csv or jsonl)", format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".ndjson":
+		return InputJSONL, nil
+	}
+	return InputCSV, nil
+}
+
+// QueriesFromInput parses query specifications in the format from r and binds them to the template.
+func queriesFromInput(r io.Reader, format string, options CSVOptions, tmpl device.Template, opts ...queryOption) ([]device.Query, error) {
+	return readQueries(newQueryReader(r, format, options, tmpl, opts...))
+}
+
+// NewQueryReader returns a queryReader of query specifications in the format from r.
+// The time layouts and location of the options only apply to CSV, but lenient skips bad lines of JSONL too.
+func newQueryReader(r io.Reader, format string, options CSVOptions, tmpl device.Template, opts ...queryOption) queryReader {
+	if format == InputJSONL {
+		qs := newJSONLQueries(r, tmpl, opts...)
+		qs.lenient = options.Lenient
+		return qs
+	}
+	return newCSVQueries(csv.NewReader(r), options, tmpl, opts...)
+}
+
+// Fields of a JSONL query specification which are not bound as params.
+const (
+	specTemplate     = "template"
+	specWeight       = "weight"
+	specTag          = "tag"
+	specExpectedRows = "expected_rows"
+)
+
+// SpecQuery is a query with the optional fields of a JSONL query specification.
+type specQuery struct {
+	device.Query
+
+	// Weight is the relative time the query is expected to take, which replaces the QueryWeight of the
+	// "lpt" balancer. If zero, the QueryWeight is used.
+	Weight float64
+
+	// Tag labels the query in the report.
+	Tag string
+
+	// ExpectedRows is the number of rows the query should return, or nil if it is unknown.
+	ExpectedRows *int
+}
+
+// QueriesFromJSONL parses query specifications from a JSON Lines file, where each line is an object with
+// the name of a query template, defaulting to tmpl, and its params.
+func queriesFromJSONL(r io.Reader, tmpl device.Template, opts ...queryOption) ([]device.Query, error) {
+	return readQueries(newJSONLQueries(r, tmpl, opts...))
+}
+
+// JsonlQueries binds each line of a JSON Lines file to its template.
+type jsonlQueries struct {
+	scanner *bufio.Scanner
+	line    int
+	tmpl    device.Template
+	opts    []queryOption
+	skips   skippedRows
+	lenient bool
+
+	// Templates are cached by name, so each one is looked up once.
+	templates map[string]device.Template
+}
+
+func newJSONLQueries(r io.Reader, tmpl device.Template, opts ...queryOption) *jsonlQueries {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	return &jsonlQueries{
+		scanner:   scanner,
+		tmpl:      tmpl,
+		opts:      opts,
+		templates: map[string]device.Template{tmpl.Name(): tmpl},
+	}
+}
+
+func (qs *jsonlQueries) next() (device.Query, error) {
+	for qs.scanner.Scan() {
+		qs.line++
+		if len(bytes.TrimSpace(qs.scanner.Bytes())) == 0 {
+			continue
+		}
+		query, err := parseQuerySpec(qs.scanner.Bytes(), qs.tmpl, qs.templates, qs.opts)
+		if err != nil && qs.lenient {
+			qs.skips.skip(&rowError{qs.line, err})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JSONL parsing failed: %w", &rowError{qs.line, err})
+		}
+		return query, nil
+	}
+	if err := qs.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("JSONL parsing failed: line %d: %w", qs.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+func (qs *jsonlQueries) skipped() skippedRows {
+	return qs.skips
+}
+
+// ParseQuerySpec binds a line of a JSONL file to its template. Templates are looked up by name once,
+// and cached in templates.
+func parseQuerySpec(data []byte, tmpl device.Template, templates map[string]device.Template, opts []queryOption) (device.Query, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	fields := map[string]any{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	spec := &specQuery{}
+	params := device.Params{}
+
+	for name, value := range fields {
+		var err error
+		switch name {
+		case specTemplate:
+			templateName, ok := value.(string)
+			if !ok {
+				return nil, errors.New("template must be a string")
+			}
+			if tmpl, ok = templates[templateName]; !ok {
+				if tmpl, err = loadTemplate(templateName); err != nil {
+					return nil, err
+				}
+				templates[templateName] = tmpl
+			}
+		case specWeight:
+			if spec.Weight, err = specNumber(value).Float64(); err != nil || spec.Weight < 0 {
+				return nil, errors.New("weight must be a non-negative number")
+			}
+		case specTag:
+			spec.Tag = fmt.Sprint(value)
+		case specExpectedRows:
+			n, err := specNumber(value).Int64()
+			if err != nil || n < 0 {
+				return nil, errors.New("expected_rows must be a non-negative integer")
+			}
+			rows := int(n)
+			spec.ExpectedRows = &rows
+		default:
+			// Numbers are bound as strings, which are parsed as the type of the placeholder.
+			if n, ok := value.(json.Number); ok {
+				value = n.String()
+			}
+			params[name] = value
+		}
+	}
+
+	for _, option := range opts {
+		option(params)
+	}
+
+	query, err := tmpl.Bind(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Weight == 0 && spec.Tag == "" && spec.ExpectedRows == nil {
+		return query, nil
+	}
+	spec.Query = query
+	return spec, nil
+}
+
+// SpecNumber returns a JSON number, or an invalid number if the value isn't one.
+func specNumber(value any) json.Number {
+	n, _ := value.(json.Number)
+	return n
+}
+
+// SpecOf returns the JSONL specification of a query, or an empty one if the query has none.
+func specOf(q device.Query) *specQuery {
+	if spec, ok := q.(*specQuery); ok {
+		return spec
+	}
+	return &specQuery{Query: q}
+}
+
+// WithSpecWeight returns a QueryWeight of the weight of each query's specification if it has one,
+// and of weight otherwise.
+func withSpecWeight(weight QueryWeight) QueryWeight {
+	return func(q device.Query) (float64, error) {
+		if w := specOf(q).Weight; w > 0 {
+			return w, nil
+		}
+		return weight(q)
+	}
+}
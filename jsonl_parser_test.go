@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+const testJSONLData = `{"hostname": "host_000001", "start_time": "2017-01-01 08:59:22", "end_time": "2017-01-01 09:59:22", "bucket_size": "5m"}
+
+{"template": "last_point", "hostname": "host_000002", "start_time": "2017-01-02T13:02:02Z", "end_time": "2017-01-02T14:02:02Z", "weight": 2.5, "tag": "latest", "expected_rows": 1}
+{"hostname": "host_000003", "start_time": "2017-01-02 18:50:28", "end_time": "2017-01-02 19:50:28"}
+`
+
+func TestJSONLParser(t *testing.T) {
+	queries, err := queriesFromJSONL(strings.NewReader(testJSONLData), device.MinMaxCPUTemplate, defaultBucketSize("1m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 {
+		t.Fatalf("expected 3 queries but got %d", len(queries))
+	}
+
+	if q, ok := queries[0].(*device.MinMaxCPUQuery); !ok || q.BucketSize != "5m" {
+		t.Errorf("expected a min_max query with the bucket size of its spec but got %#v", queries[0])
+	}
+	if q, ok := queries[2].(*device.MinMaxCPUQuery); !ok || q.BucketSize != "1m" {
+		t.Errorf("expected a min_max query with the default bucket size but got %#v", queries[2])
+	}
+
+	spec := specOf(queries[1])
+	if spec.Template() != "last_point" || spec.Host() != "host_000002" {
+		t.Errorf("expected a last_point query of host_000002 but got %s %s", spec.Template(), spec)
+	}
+	if spec.Weight != 2.5 || spec.Tag != "latest" || spec.ExpectedRows == nil || *spec.ExpectedRows != 1 {
+		t.Errorf("expected the optional fields of the spec but got %+v", spec)
+	}
+	if w, err := withSpecWeight(TimeRangeWeight)(queries[1]); err != nil || w != 2.5 {
+		t.Errorf("expected the weight of the spec but got %f (%v)", w, err)
+	}
+	if w, err := withSpecWeight(TimeRangeWeight)(queries[0]); err != nil || w != 60 {
+		t.Errorf("expected the time range weight of a query without a spec weight but got %f (%v)", w, err)
+	}
+}
+
+func TestJSONLParserErrors(t *testing.T) {
+	for data, expect := range map[string]string{
+		strings.SplitAfter(testJSONLData, "\n")[0] + `{"hostname": `: "line 2",
+		`{"template": "unknown", "hostname": "host_000001"}`:         "unknown query template",
+		`{"hostname": "host_000001", "weight": "heavy"}`:             "weight must be",
+		`{"hostname": "host_000001", "expected_rows": 1.5}`:          "expected_rows must be",
+		`{"hostname": "host_000001", "start_time": "yesterday"}`:     "param start_time",
+	} {
+		_, err := queriesFromJSONL(strings.NewReader(data), device.MinMaxCPUTemplate)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected an error containing %q for %s but got %v", expect, data, err)
+		}
+	}
+}
+
+func TestJSONLParserLenient(t *testing.T) {
+	data := `{"hostname": "host_000001", "weight": "heavy"}` + "\n" + testJSONLData + `{"hostname": `
+	reader := newQueryReader(strings.NewReader(data), InputJSONL, CSVOptions{Lenient: true}, device.MinMaxCPUTemplate, defaultBucketSize("1m"))
+	queries, err := readQueries(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 {
+		t.Errorf("expected the 3 valid queries but got %d", len(queries))
+	}
+	if s := reader.skipped(); s.count != 2 || !strings.HasPrefix(s.examples[0].Error(), "line 1: weight must be") {
+		t.Errorf("expected 2 skipped lines but got %+v", s)
+	}
+}
+
+func TestInputFormat(t *testing.T) {
+	for _, test := range []struct {
+		format, filename, expect string
+	}{
+		{"", "queries.csv", InputCSV},
+		{"", "queries.JSONL", InputJSONL},
+		{"", "queries.ndjson", InputJSONL},
+		{"", "/dev/stdin", InputCSV},
+		{InputJSONL, "queries.csv", InputJSONL},
+	} {
+		if format, err := inputFormat(test.format, test.filename); err != nil || format != test.expect {
+			t.Errorf("expected format %s for %q and %q but got %s (%v)", test.expect, test.format, test.filename, format, err)
+		}
+	}
+	if _, err := inputFormat("xml", "queries.xml"); err == nil {
+		t.Error("expected an error for an unknown input format")
+	}
+}
+
+func TestBenchmarkCommandJSONL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3)
+
+	path := filepath.Join(t.TempDir(), "queries.jsonl")
+	if err := os.WriteFile(path, []byte(testJSONLData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := &BenchmarkCommand{CSV: f, DB: db, Concurrency: 2, Reporter: JSONReporter{}, Output: &out, Log: io.Discard}
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal("benchmark failed:", err)
+	}
+	if !strings.Contains(out.String(), `"tag": "latest"`) {
+		t.Errorf("expected the tag of a query in the report but got:\n%s", out.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,20 +6,69 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/sbward/ts-query-workers/device"
 )
 
 var (
 	concurrency = flag.Int("c", 5, "number of concurrent workers (defaults to 5)")
 	connStr     = flag.String("db", os.Getenv("DB"), "database connection string (defaults to DB environment variable)")
+	exact       = flag.Bool("exact", false, "compute exact percentiles by keeping every measurement in memory")
+	format      = flag.String("format", "text", "report format: text, json, csv or markdown")
+	outputFile  = flag.String("o", "", "write the report to a file instead of stdout")
+	inputFmt    = flag.String("input-format", "", "format of the query specifications: csv or jsonl (defaults to jsonl for .jsonl files, csv otherwise)")
+	timeLayout  = flag.String("time-layout", csvTimeFormat, "comma-separated layouts of CSV times, tried in order: a Go time layout, rfc3339, unix or unix_ms")
+	timezone    = flag.String("timezone", "UTC", "IANA time zone of CSV times without an offset, e.g. America/New_York")
+	lenient     = flag.Bool("lenient", false, "skip rows of the input that can't be read and report them, instead of failing")
+	skipValid   = flag.Bool("skip-validation", false, "start the benchmark even if validating the queries found errors")
+	validation  = validationFlags(flag.CommandLine)
+	stream      = flag.Bool("stream", false, "read queries while they are executed instead of loading the whole file first")
+	template    = flag.String("template", "min_max", "name of a built-in query template, or path to a SQL template file")
+	duration    = flag.Duration("duration", 0, "keep replaying the queries for a duration, e.g. 5m (defaults to a single pass)")
+	rate        = flag.Float64("rate", 0, "target rate of queries per second for an open-loop benchmark (defaults to closed loop)")
+	arrival     = flag.String("arrival", "poisson", "arrival process of an open-loop benchmark: poisson or constant")
+	iterations  = flag.Int("iterations", 1, "number of times to run the whole workload")
+	warmup      = flag.Int("warmup", 0, "number of warm-up queries to execute before the benchmark, excluded from statistics")
+	warmupDur   = flag.Duration("warmup-duration", 0, "duration of warm-up queries to execute before the benchmark, excluded from statistics")
+	maxErrors   = flag.Int("max-errors", 0, "abort the benchmark once more queries than this have failed (defaults to never)")
+	balancer    = flag.String("balancer", "hash", "assignment of hosts to workers: hash, ring, rendezvous, host-id, random or lpt")
+	weight      = flag.String("weight", "range", "query weight of the lpt balancer: range (length of the time range) or cost (estimated by EXPLAIN)")
+	scheduler   = flag.String("scheduler", "hash", "strategy for distributing queries to workers: hash, shared or steal")
+	mode        = flag.String("mode", "explain", "how queries are measured: explain (server-side time), exec (client round trip) or both")
+	quiet       = flag.Bool("quiet", false, "don't print a line per query, only the progress")
+	queryLog    = flag.String("query-log", "", "write a line per query to a file instead of the progress output")
+	metricsAddr = flag.String("metrics-addr", "", "serve live metrics for Prometheus on an address, e.g. :9100")
+	timeout     = flag.Duration("query-timeout", 0, "cancel a query attempt that runs for longer, e.g. 30s (defaults to no timeout)")
+	maxAttempts = flag.Int("max-attempts", 1, "number of times to execute a query that failed with a transient error")
+	backoff     = flag.Duration("retry-backoff", 100*time.Millisecond, "delay before retrying a query, doubled after every attempt")
 )
 
+// Command is implemented by the benchmark command and each subcommand.
+type Command interface {
+	Exec(ctx context.Context) error
+}
+
+// Subcommands maps the name of each subcommand to a function that reads its configuration from the
+// arguments following the name. Without a subcommand name, the arguments configure a BenchmarkCommand.
+var subcommands = map[string]func(args []string) (Command, error){
+	"compare":     func(args []string) (Command, error) { return NewCompareCommandFromCLI(args) },
+	"balance":     func(args []string) (Command, error) { return NewBalanceCommandFromCLI(args) },
+	"validate":    func(args []string) (Command, error) { return NewValidateCommandFromCLI(args) },
+	"gen-queries": func(args []string) (Command, error) { return NewGenQueriesCommandFromCLI(args) },
+}
+
 func main() {
-	cmd, err := NewCommandFromCLI(os.Args)
+	cmd, err := newCommand(os.Args)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -28,11 +77,43 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	go cancelOnSignal(cancel)
+
 	if err = cmd.Exec(ctx); err != nil {
+		// Regressions exit with a distinct status, so CI can tell them apart from failures.
+		if errors.Is(err, ErrRegression) {
+			fmt.Println(err)
+			os.Exit(2)
+		}
 		log.Fatal(err)
 	}
 }
 
+// CancelOnSignal cancels the context on the first SIGINT or SIGTERM, so the command can stop
+// gracefully and report the results gathered so far. A second signal exits immediately.
+func cancelOnSignal(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+	fmt.Fprintln(os.Stderr, "Interrupted, stopping after in-flight queries. Interrupt again to exit immediately.")
+	cancel()
+
+	<-signals
+	os.Exit(130)
+}
+
+// NewCommand reads the configuration of the subcommand named by the first argument,
+// or of a BenchmarkCommand if there is no subcommand name.
+func newCommand(args []string) (Command, error) {
+	if len(args) > 1 {
+		if sub, ok := subcommands[args[1]]; ok {
+			return sub(args[2:])
+		}
+	}
+	return NewCommandFromCLI(args)
+}
+
 // NewCommandFromCLI reads input and configuration for a BenchmarkCommand from flags and stdin.
 func NewCommandFromCLI(args []string) (*BenchmarkCommand, error) {
 	flag.Parse()
@@ -47,15 +128,81 @@ func NewCommandFromCLI(args []string) (*BenchmarkCommand, error) {
 		return nil, err
 	}
 
+	tmpl, err := getTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	csvOptions, err := getCSVOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := validation()
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, err := NewReporter(*format)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
 	}
 
+	// Files are created once nothing else can fail, so a failed setup doesn't leave them behind.
+
+	output, logOutput, err := getOutputs()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var queryLogFile io.Writer
+	if *queryLog != "" {
+		if queryLogFile, err = os.Create(*queryLog); err != nil {
+			db.Close()
+			if output != os.Stdout {
+				output.Close()
+				os.Remove(output.Name())
+			}
+			return nil, fmt.Errorf("failed to create query log: %w", err)
+		}
+	}
+
 	cmd := &BenchmarkCommand{
-		CSV:         f,
-		DB:          db,
-		Concurrency: *concurrency,
+		CSV:              f,
+		InputFormat:      *inputFmt,
+		CSVOptions:       csvOptions,
+		Validation:       v,
+		SkipValidation:   *skipValid,
+		Stream:           *stream,
+		DB:               db,
+		Concurrency:      *concurrency,
+		Template:         tmpl,
+		ExactPercentiles: *exact,
+		Reporter:         reporter,
+		Output:           output,
+		Log:              logOutput,
+		Duration:         *duration,
+		Rate:             *rate,
+		Arrival:          *arrival,
+		Iterations:       *iterations,
+		WarmupQueries:    *warmup,
+		WarmupDuration:   *warmupDur,
+		MaxErrors:        *maxErrors,
+		Balancer:         *balancer,
+		Weight:           *weight,
+		Scheduler:        *scheduler,
+		Mode:             *mode,
+		QueryTimeout:     *timeout,
+		MetricsAddr:      *metricsAddr,
+		Quiet:            *quiet,
+		QueryLog:         queryLogFile,
+		Retry:            RetryPolicy{MaxAttempts: *maxAttempts, Backoff: *backoff},
 	}
 
 	return cmd, nil
@@ -88,3 +235,46 @@ func getConnStr() (string, error) {
 	}
 	return *connStr, nil
 }
+
+// GetCSVOptions reads the time layouts, time zone and leniency of the input.
+func getCSVOptions() (CSVOptions, error) {
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return CSVOptions{}, fmt.Errorf("invalid timezone %q: %w", *timezone, err)
+	}
+	return CSVOptions{
+		TimeLayouts: strings.Split(*timeLayout, ","),
+		Location:    loc,
+		Lenient:     *lenient,
+	}, nil
+}
+
+// GetOutputs opens the report destination and selects where progress messages are written.
+// Progress messages go to stderr when a machine-readable report is written to stdout.
+func getOutputs() (output *os.File, logOutput *os.File, err error) {
+	if *outputFile == "" {
+		if *format != "text" {
+			return os.Stdout, os.Stderr, nil
+		}
+		return os.Stdout, os.Stdout, nil
+	}
+	output, err = os.Create(*outputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create report file: %w", err)
+	}
+	return output, os.Stdout, nil
+}
+
+// GetTemplate loads the query template named by the -template flag.
+func getTemplate() (device.Template, error) {
+	return loadTemplate(*template)
+}
+
+// LoadTemplate loads a query template, which is a SQL file if the name has a .sql extension,
+// and a built-in template otherwise.
+func loadTemplate(name string) (device.Template, error) {
+	if filepath.Ext(name) == ".sql" {
+		return device.LoadTemplate(name)
+	}
+	return device.LookupTemplate(name)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetricsPrefix is the prefix of the name of every exposed metric.
+const metricsPrefix = "ts_query_workers_"
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of the latency and execution time histograms.
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// CostBuckets are the upper bounds of the buckets of the cost histogram.
+var CostBuckets = []float64{10, 100, 1e3, 1e4, 1e5, 1e6, 1e7}
+
+// LiveMetrics collects metrics of the queries executed by each worker while a benchmark is running,
+// and serves them over HTTP in the Prometheus text exposition format.
+// Every metric is labelled by worker and query template. A nil *LiveMetrics discards observations.
+type LiveMetrics struct {
+	mu sync.Mutex
+
+	latency       map[metricLabels]*histogram
+	executionTime map[metricLabels]*histogram
+	cost          map[metricLabels]*histogram
+	queries       map[metricLabels]int
+	inFlight      map[metricLabels]int
+	errors        map[errorLabels]int
+}
+
+// MetricLabels identify the series of a worker and query template.
+type metricLabels struct {
+	worker   int
+	template string
+}
+
+type errorLabels struct {
+	metricLabels
+	class ErrorClass
+}
+
+var _ http.Handler = (*LiveMetrics)(nil)
+
+// NewLiveMetrics returns LiveMetrics without any observations.
+func NewLiveMetrics() *LiveMetrics {
+	return &LiveMetrics{
+		latency:       map[metricLabels]*histogram{},
+		executionTime: map[metricLabels]*histogram{},
+		cost:          map[metricLabels]*histogram{},
+		queries:       map[metricLabels]int{},
+		inFlight:      map[metricLabels]int{},
+		errors:        map[errorLabels]int{},
+	}
+}
+
+// Start counts a query that a worker started executing.
+func (m *LiveMetrics) Start(worker int, template string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[metricLabels{worker, template}]++
+}
+
+// Finish observes the result of a query that was counted by Start.
+func (m *LiveMetrics) Finish(result *QueryExecutionResult) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := metricLabels{result.Worker, result.Query.Template()}
+	m.inFlight[labels]--
+	m.queries[labels]++
+
+	if result.Error != nil {
+		m.errors[errorLabels{labels, classifyError(result.Error)}]++
+		return
+	}
+
+	observe(m.latency, labels, LatencyBuckets, result.Latency.Seconds())
+	if !explained(result.Mode) {
+		return
+	}
+	observe(m.executionTime, labels, LatencyBuckets, result.Stats.ExecutionTime.Seconds())
+	observe(m.cost, labels, CostBuckets, float64(result.Stats.Cost))
+}
+
+// Cancel stops counting a query that was counted by Start, but whose result was discarded.
+func (m *LiveMetrics) Cancel(worker int, template string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[metricLabels{worker, template}]--
+}
+
+func observe(histograms map[metricLabels]*histogram, labels metricLabels, buckets []float64, x float64) {
+	h, ok := histograms[labels]
+	if !ok {
+		h = newHistogram(buckets)
+		histograms[labels] = h
+	}
+	h.observe(x)
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format.
+func (m *LiveMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (m *LiveMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	writeHistograms(&b, "query_latency_seconds", "Time from the scheduled start of a query until its result was received.", m.latency)
+	writeHistograms(&b, "query_execution_seconds", "Server-side execution time reported by EXPLAIN ANALYZE.", m.executionTime)
+	writeHistograms(&b, "query_cost", "Total cost estimated by the query planner.", m.cost)
+
+	writeHeader(&b, "queries_total", "counter", "Queries completed by each worker, including failed queries.")
+	for _, labels := range sortedLabels(m.queries) {
+		fmt.Fprintf(&b, "%squeries_total{%s} %d\n", metricsPrefix, labels, m.queries[labels])
+	}
+
+	writeHeader(&b, "queries_in_flight", "gauge", "Queries currently being executed by each worker.")
+	for _, labels := range sortedLabels(m.inFlight) {
+		fmt.Fprintf(&b, "%squeries_in_flight{%s} %d\n", metricsPrefix, labels, m.inFlight[labels])
+	}
+
+	writeHeader(&b, "query_errors_total", "counter", "Failed queries by error class.")
+	errorSeries := make([]errorLabels, 0, len(m.errors))
+	for labels := range m.errors {
+		errorSeries = append(errorSeries, labels)
+	}
+	sort.Slice(errorSeries, func(i, j int) bool {
+		if errorSeries[i].metricLabels != errorSeries[j].metricLabels {
+			return errorSeries[i].metricLabels.less(errorSeries[j].metricLabels)
+		}
+		return errorSeries[i].class < errorSeries[j].class
+	})
+	for _, labels := range errorSeries {
+		fmt.Fprintf(&b, "%squery_errors_total{%s,class=%s} %d\n", metricsPrefix, labels.metricLabels, quoteLabel(string(labels.class)), m.errors[labels])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+func writeHistograms(b *strings.Builder, name, help string, histograms map[metricLabels]*histogram) {
+	writeHeader(b, name, "histogram", help)
+	for _, labels := range sortedLabels(histograms) {
+		h := histograms[labels]
+		for i, le := range h.buckets {
+			fmt.Fprintf(b, "%s%s_bucket{%s,le=\"%s\"} %d\n", metricsPrefix, name, labels, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s%s_bucket{%s,le=\"+Inf\"} %d\n", metricsPrefix, name, labels, h.count)
+		fmt.Fprintf(b, "%s%s_sum{%s} %s\n", metricsPrefix, name, labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s%s_count{%s} %d\n", metricsPrefix, name, labels, h.count)
+	}
+}
+
+// String formats the labels for the exposition format.
+func (l metricLabels) String() string {
+	return fmt.Sprintf("worker=\"%d\",template=%s", l.worker, quoteLabel(l.template))
+}
+
+func (l metricLabels) less(other metricLabels) bool {
+	if l.worker != other.worker {
+		return l.worker < other.worker
+	}
+	return l.template < other.template
+}
+
+func sortedLabels[V any](series map[metricLabels]V) []metricLabels {
+	labels := make([]metricLabels, 0, len(series))
+	for l := range series {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].less(labels[j]) })
+	return labels
+}
+
+// QuoteLabel quotes a label value, escaping backslashes, double quotes and line feeds.
+func quoteLabel(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+// Histogram counts observations in cumulative buckets, like a Prometheus histogram.
+type histogram struct {
+	buckets []float64
+	counts  []int
+	count   int
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]int, len(buckets))}
+}
+
+func (h *histogram) observe(x float64) {
+	for i, le := range h.buckets {
+		if x <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += x
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestLiveMetrics(t *testing.T) {
+	metrics := NewLiveMetrics()
+
+	query := &device.MinMaxCPUQuery{Hostname: "host_000001"}
+	for _, result := range []*QueryExecutionResult{
+		{Query: query, Worker: 0, Latency: 3 * time.Millisecond, Stats: &device.QueryStats{ExecutionTime: 2 * time.Millisecond, Cost: 50}},
+		{Query: query, Worker: 0, Latency: 30 * time.Millisecond, Stats: &device.QueryStats{ExecutionTime: 20 * time.Millisecond, Cost: 500}},
+		{Query: query, Worker: 1, Error: errors.New("boom")},
+	} {
+		metrics.Start(result.Worker, query.Template())
+		metrics.Finish(result)
+	}
+
+	// Worker 1 is still executing a query when the metrics are scraped.
+	metrics.Start(1, query.Template())
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(body)
+
+	for _, line := range []string{
+		"# TYPE ts_query_workers_query_latency_seconds histogram",
+		`ts_query_workers_query_latency_seconds_bucket{worker="0",template="min_max",le="0.005"} 1`,
+		`ts_query_workers_query_latency_seconds_bucket{worker="0",template="min_max",le="+Inf"} 2`,
+		`ts_query_workers_query_latency_seconds_sum{worker="0",template="min_max"} 0.033`,
+		`ts_query_workers_query_execution_seconds_count{worker="0",template="min_max"} 2`,
+		`ts_query_workers_query_cost_bucket{worker="0",template="min_max",le="100"} 1`,
+		"# TYPE ts_query_workers_queries_total counter",
+		`ts_query_workers_queries_total{worker="0",template="min_max"} 2`,
+		`ts_query_workers_queries_total{worker="1",template="min_max"} 1`,
+		`ts_query_workers_queries_in_flight{worker="1",template="min_max"} 1`,
+		`ts_query_workers_query_errors_total{worker="1",template="min_max",class="other"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("expected metrics to contain %s", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(text)
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if q := quoteLabel("a\"b\\c\nd"); q != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected quoted label %s", q)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// Modes are the ways of measuring a query.
+const (
+	// ModeExplain executes each query with EXPLAIN ANALYZE and measures the server-side planning and execution time.
+	ModeExplain = "explain"
+
+	// ModeExec executes each query from the client and measures the round trip until every row was received,
+	// and the number of rows and bytes received.
+	ModeExec = "exec"
+
+	// ModeBoth executes each query with EXPLAIN ANALYZE and then from the client, to estimate the overhead of the round trip.
+	ModeBoth = "both"
+)
+
+// Modes are the names of all modes.
+var Modes = []string{ModeExplain, ModeExec, ModeBoth}
+
+// ValidateMode returns an error if the mode name is unknown.
+func validateMode(mode string) error {
+	for _, m := range Modes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown mode %q (expected explain, exec or both)", mode)
+}
+
+// Measure executes a query once according to the mode.
+func measure(ctx context.Context, mode string, tx device.QuerierCtx, query device.Query) (*device.QueryStats, error) {
+	switch mode {
+	case ModeExec:
+		return query.ExecCtx(ctx, tx)
+
+	case ModeBoth:
+		stats, err := query.ExplainAnalyze(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		exec, err := query.ExecCtx(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		stats.RoundTrip, stats.Rows, stats.Bytes = exec.RoundTrip, exec.Rows, exec.Bytes
+		return stats, nil
+
+	default:
+		return query.ExplainAnalyze(ctx, tx)
+	}
+}
+
+// Explained returns whether queries measured in the mode have server-side statistics from EXPLAIN ANALYZE.
+func explained(mode string) bool {
+	return mode != ModeExec
+}
+
+// Executed returns whether queries measured in the mode have client-side statistics from executing them.
+func executed(mode string) bool {
+	return mode == ModeExec || mode == ModeBoth
+}
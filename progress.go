@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sbward/ts-query-workers/stats"
+)
+
+// ProgressWindow is the number of most recent queries the rolling percentiles of a progress display are computed from.
+const progressWindow = 1000
+
+// Progress intervals are the minimum time between updates of a progress display on a terminal,
+// and between progress lines otherwise.
+var (
+	progressTerminalInterval = 200 * time.Millisecond
+	progressLineInterval     = 10 * time.Second
+)
+
+// Progress displays the number of completed queries of a benchmark phase, the rate of queries per second,
+// rolling percentiles of latency and the number of errors. On a terminal, the display is refreshed in place,
+// otherwise a line is written periodically.
+type progress struct {
+	out      io.Writer
+	terminal bool
+
+	// Total is the number of queries of the phase, or 0 if it is unknown because the phase has a duration.
+	total int
+
+	start     time.Time
+	completed int
+	errors    int
+
+	// Recent are the latencies of the most recent successful queries, in a ring buffer.
+	recent []time.Duration
+	next   int
+
+	drawn    bool
+	lastDraw time.Time
+}
+
+func newProgress(out io.Writer, total int) *progress {
+	return &progress{
+		out:      out,
+		terminal: isTerminal(out),
+		total:    total,
+		start:    time.Now(),
+		recent:   make([]time.Duration, 0, progressWindow),
+	}
+}
+
+// IsTerminal returns whether w is a file of a character device, such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Observe counts a query result, and updates the display if it is due.
+func (p *progress) observe(result *QueryExecutionResult) {
+	p.completed++
+	if result.Error != nil {
+		p.errors++
+	} else if len(p.recent) < progressWindow {
+		p.recent = append(p.recent, result.Latency)
+	} else {
+		p.recent[p.next] = result.Latency
+		p.next = (p.next + 1) % progressWindow
+	}
+
+	interval := progressLineInterval
+	if p.terminal {
+		interval = progressTerminalInterval
+	}
+	if time.Since(p.lastDraw) >= interval {
+		p.draw()
+	}
+}
+
+// Println writes a line above the display, so it isn't overwritten by the next update.
+func (p *progress) println(a ...any) {
+	if p.terminal && p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		fmt.Fprintln(p.out, a...)
+		p.draw()
+		return
+	}
+	fmt.Fprintln(p.out, a...)
+}
+
+// Done updates the display a final time.
+func (p *progress) done() {
+	p.draw()
+	if p.terminal {
+		fmt.Fprintln(p.out)
+	}
+}
+
+func (p *progress) draw() {
+	p.lastDraw = time.Now()
+	if p.terminal {
+		fmt.Fprintf(p.out, "\r\033[K%s", p)
+		p.drawn = true
+	} else {
+		fmt.Fprintln(p.out, p)
+	}
+}
+
+func (p *progress) String() string {
+	completed := fmt.Sprint(p.completed)
+	if p.total > 0 {
+		completed = fmt.Sprintf("%d/%d (%.0f%%)", p.completed, p.total, float64(p.completed)/float64(p.total)*100)
+	}
+
+	qps := 0.0
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		qps = float64(p.completed) / elapsed
+	}
+
+	s := fmt.Sprintf("%s queries, %.1f queries/s", completed, qps)
+
+	if len(p.recent) > 0 {
+		q := stats.NewExact[time.Duration]()
+		for _, latency := range p.recent {
+			q.Push(latency)
+		}
+		s += fmt.Sprintf(", p50 %s, p99 %s",
+			time.Duration(q.Quantile(0.5)).Round(time.Microsecond),
+			time.Duration(q.Quantile(0.99)).Round(time.Microsecond))
+	}
+
+	return s + fmt.Sprintf(", %d errors", p.errors)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, 4)
+
+	query := &device.MinMaxCPUQuery{Hostname: "host_000001"}
+	for _, latency := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond} {
+		p.observe(&QueryExecutionResult{Query: query, Latency: latency})
+	}
+	p.observe(&QueryExecutionResult{Query: query, Error: errors.New("boom")})
+
+	s := p.String()
+	for _, part := range []string{"4/4 (100%) queries", "p50 2ms", "p99 2.98ms", "1 errors"} {
+		if !strings.Contains(s, part) {
+			t.Errorf("expected progress %q to contain %q", s, part)
+		}
+	}
+
+	// Without a terminal, a progress line is written for the first query, then only periodically.
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("expected 1 progress line but got %d:\n%s", n, buf.String())
+	}
+}
+
+func TestProgressTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgress(&buf, 0)
+	p.terminal = true
+
+	p.observe(&QueryExecutionResult{Query: &device.MinMaxCPUQuery{}, Latency: time.Millisecond})
+	p.println("query result")
+	p.done()
+
+	// The display is cleared before the line is written above it, then redrawn.
+	expect := "\r\033[K1 queries, "
+	if out := buf.String(); !strings.HasPrefix(out, expect) || !strings.Contains(out, "\r\033[Kquery result\n\r\033[K1 queries") {
+		t.Errorf("unexpected terminal output %q", out)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/lib/pq"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// ErrorClass groups query errors by their cause.
+type ErrorClass string
+
+const (
+	// ErrorTimeout is a query that was cancelled by a deadline or a statement timeout.
+	ErrorTimeout ErrorClass = "timeout"
+
+	// ErrorConnection is a query that failed because the connection to the database failed or was lost.
+	ErrorConnection ErrorClass = "connection"
+
+	// ErrorSQL is a query that was rejected by the database, e.g. for a syntax error or a missing table.
+	ErrorSQL ErrorClass = "sql"
+
+	// ErrorPlanParse is a query whose EXPLAIN output could not be read as a query plan.
+	ErrorPlanParse ErrorClass = "plan_parse"
+
+	// ErrorOther is any other query error.
+	ErrorOther ErrorClass = "other"
+)
+
+// ErrorClasses are all error classes in the order they are reported.
+var ErrorClasses = []ErrorClass{ErrorTimeout, ErrorConnection, ErrorSQL, ErrorPlanParse, ErrorOther}
+
+// ClassifyError returns the class of a query error.
+func classifyError(err error) ErrorClass {
+	var pqErr *pq.Error
+	var planErr *device.PlanError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &pqErr):
+		switch {
+		case pqErr.Code == "57014": // query_canceled, raised by statement_timeout.
+			return ErrorTimeout
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "57": // connection_exception, operator_intervention.
+			return ErrorConnection
+		}
+		return ErrorSQL
+	case errors.As(err, &planErr):
+		return ErrorPlanParse
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &netErr),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET):
+		return ErrorConnection
+	}
+	return ErrorOther
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorTimeout},
+		{&pq.Error{Code: "57014"}, ErrorTimeout},
+		{&pq.Error{Code: "08006"}, ErrorConnection},
+		{fmt.Errorf("query: %w", driver.ErrBadConn), ErrorConnection},
+		{&pq.Error{Code: "42P01"}, ErrorSQL},
+		{&device.PlanError{Err: errors.New("expected 1 plan result but got 0")}, ErrorPlanParse},
+		{errors.New("unexpected"), ErrorOther},
+	}
+	for _, test := range tests {
+		if got := classifyError(test.err); got != test.want {
+			t.Errorf("expected %v to be classified as %s but got %s", test.err, test.want, got)
+		}
+	}
+}
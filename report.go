@@ -0,0 +1,704 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+	"github.com/sbward/ts-query-workers/stats"
+)
+
+// Report is the outcome of a benchmark run.
+type Report struct {
+	// Stats are the statistics of every iteration combined.
+	Stats BenchmarkStats
+
+	// Iterations are the statistics of each iteration.
+	Iterations []BenchmarkStats
+
+	// Results are the result of every query, in the order they completed, only collected for a Reporter that writes them.
+	Results []*QueryExecutionResult
+
+	// Partial is true if the benchmark was interrupted, so the report only includes the results gathered until then.
+	Partial bool
+
+	// Warmup summarizes the warm-up phase, or is nil if there was none.
+	Warmup *WarmupReport
+
+	// Scheduler is the name of the strategy that distributed queries to workers.
+	Scheduler string
+
+	// Skipped summarizes the rows of the input that were skipped because they couldn't be read, or because a
+	// streamed query failed validation, or is nil if there were none.
+	Skipped *SkippedReport
+}
+
+// WarmupReport summarizes the queries executed during the warm-up phase, which are excluded from Stats.
+type WarmupReport struct {
+	Queries  int
+	Errors   int
+	Duration time.Duration
+}
+
+func (w *WarmupReport) String() string {
+	return fmt.Sprintf("%d queries (%d errors) in %s, excluded from statistics", w.Queries, w.Errors, w.Duration.Round(time.Millisecond))
+}
+
+// SkippedReport summarizes the rows of the input that a lenient reader skipped.
+type SkippedReport struct {
+	// Rows is the number of skipped rows.
+	Rows int
+
+	// Errors are the errors of the first skipped rows.
+	Errors []string
+}
+
+// NewSkippedReport returns the summary of skipped rows, or nil if no row was skipped.
+func newSkippedReport(s skippedRows) *SkippedReport {
+	if s.count == 0 {
+		return nil
+	}
+	r := &SkippedReport{Rows: s.count}
+	for _, err := range s.examples {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	return r
+}
+
+func (s *SkippedReport) String() string {
+	return fmt.Sprintf("%d rows of the input could not be read, and were excluded from the benchmark", s.Rows)
+}
+
+// Reporter writes a Report to w in a particular output format.
+type Reporter interface {
+	Report(w io.Writer, report *Report) error
+}
+
+// QueryReporter is implemented by a Reporter that writes the result of every query,
+// which are only collected in Report.Results for such a Reporter.
+type queryReporter interface {
+	reportsQueries()
+}
+
+// ReportsQueries returns whether the Reporter writes the result of every query.
+func reportsQueries(r Reporter) bool {
+	_, ok := r.(queryReporter)
+	return ok
+}
+
+// Reporters maps each supported output format to its Reporter.
+var reporters = map[string]Reporter{
+	"text":     TextReporter{},
+	"json":     JSONReporter{},
+	"csv":      CSVReporter{},
+	"markdown": MarkdownReporter{},
+}
+
+// NewReporter returns the Reporter for an output format.
+func NewReporter(format string) (Reporter, error) {
+	r, ok := reporters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown report format %q (expected one of: %s)", format, strings.Join(reportFormats(), ", "))
+	}
+	return r, nil
+}
+
+func reportFormats() []string {
+	formats := make([]string, 0, len(reporters))
+	for format := range reporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// AcrossIterations summarizes the value of each column in the row of all workers of a metric across iterations.
+// The metric is selected by its index in BenchmarkStats.Metrics, and values are in the unit of its Key.
+func (r *Report) AcrossIterations(metric int) []stats.SampleSummary {
+	columns := make([][]float64, len(columnKeys()))
+	for _, iteration := range r.Iterations {
+		for i, x := range iteration.Metrics()[metric].Row(allWorkers) {
+			columns[i] = append(columns[i], x)
+		}
+	}
+	summaries := make([]stats.SampleSummary, len(columns))
+	for i, xs := range columns {
+		summaries[i] = stats.Summarize(xs)
+	}
+	return summaries
+}
+
+// IterationsTable returns a human-readable table of the mean, standard deviation and 95% confidence interval
+// of each column in the row of all workers of a metric across iterations.
+func (r *Report) iterationsTable(metric int) string {
+	m := r.Stats.Metrics()[metric]
+	labels := columnLabels()
+
+	table := "|  Column |    Mean | Std dev |   95% CI |\n"
+	table += "|---------|---------|---------|----------|\n"
+
+	for i, s := range r.AcrossIterations(metric) {
+		format := m.formatKeyUnit
+		if i == 0 {
+			format = func(x float64) string { return strconv.FormatFloat(x, 'f', 1, 64) }
+		}
+		table += fmt.Sprintf("| %7s | %7s | %7s | ±%7s |\n", labels[i], format(s.Mean), format(s.StdDev), format(s.CI95))
+	}
+
+	return table
+}
+
+// ScheduleTitle describes the scheduler and the makespan of the benchmark.
+func (r *Report) scheduleTitle() string {
+	scheduler := ""
+	if r.Scheduler != "" {
+		scheduler = r.Scheduler + " scheduler, "
+	}
+	return fmt.Sprintf("Worker utilization (%smakespan %s)", scheduler, r.Stats.Makespan.Round(time.Millisecond))
+}
+
+var _ Reporter = TextReporter{}
+
+// TextReporter writes the statistics tables in a human-readable layout.
+type TextReporter struct{}
+
+func (TextReporter) Report(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	if report.Partial {
+		b.WriteString("\nPARTIAL REPORT: the benchmark was interrupted before all queries completed.\n")
+	}
+
+	if report.Warmup != nil {
+		fmt.Fprintf(&b, "\nWarm-up: %s\n", report.Warmup)
+	}
+
+	if report.Skipped != nil {
+		fmt.Fprintf(&b, "\nSkipped: %s\n", report.Skipped)
+	}
+
+	for _, m := range report.Stats.Metrics() {
+		fmt.Fprintf(&b, "\n%s:\n\n%s\n", m.Title, m.Table())
+	}
+
+	if report.Stats.Errors.Global.Total() > 0 {
+		fmt.Fprintf(&b, "\nErrors:\n\n%s\n", report.Stats.errorSummary())
+	}
+
+	fmt.Fprintf(&b, "\n%s:\n\n%s\n", report.scheduleTitle(), report.Stats.scheduleTable())
+
+	if len(report.Stats.Nodes) > 0 {
+		fmt.Fprintf(&b, "\nPlan nodes:\n\n%s\n", report.Stats.nodesTable())
+	}
+
+	if len(report.Stats.Chunks.ByCount) > 0 {
+		fmt.Fprintf(&b, "\nExecution time by chunks scanned:\n\n%s\n", report.Stats.Chunks.table())
+	}
+
+	if n := len(report.Iterations); n > 1 {
+		for i, m := range report.Stats.Metrics() {
+			fmt.Fprintf(&b, "\n%s across %d iterations:\n\n%s\n", m.Title, n, report.iterationsTable(i))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var _ Reporter = MarkdownReporter{}
+
+// MarkdownReporter writes the statistics tables and every query result as a Markdown document.
+type MarkdownReporter struct{}
+
+func (MarkdownReporter) reportsQueries() {}
+
+func (MarkdownReporter) Report(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	b.WriteString("# Benchmark report\n")
+
+	if report.Partial {
+		b.WriteString("\n**Partial report:** the benchmark was interrupted before all queries completed.\n")
+	}
+
+	if report.Warmup != nil {
+		fmt.Fprintf(&b, "\nWarm-up: %s.\n", report.Warmup)
+	}
+
+	if report.Skipped != nil {
+		fmt.Fprintf(&b, "\nSkipped: %s.\n", report.Skipped)
+	}
+
+	for _, m := range report.Stats.Metrics() {
+		fmt.Fprintf(&b, "\n## %s\n\n%s", m.Title, m.Table())
+	}
+
+	if report.Stats.Errors.Global.Total() > 0 {
+		fmt.Fprintf(&b, "\n## Errors\n\n%s", report.Stats.errorSummary())
+	}
+
+	fmt.Fprintf(&b, "\n## %s\n\n%s", report.scheduleTitle(), report.Stats.scheduleTable())
+
+	if len(report.Stats.Nodes) > 0 {
+		fmt.Fprintf(&b, "\n## Plan nodes\n\n%s", report.Stats.nodesTable())
+	}
+
+	if len(report.Stats.Chunks.ByCount) > 0 {
+		fmt.Fprintf(&b, "\n## Execution time by chunks scanned\n\n%s", report.Stats.Chunks.table())
+	}
+
+	if n := len(report.Iterations); n > 1 {
+		for i, m := range report.Stats.Metrics() {
+			fmt.Fprintf(&b, "\n## %s across %d iterations\n\n%s", m.Title, n, report.iterationsTable(i))
+		}
+	}
+
+	// Columns of measurements the mode doesn't make would be zero for every query, so they are left out.
+	mode := report.Stats.Mode
+	header := []string{"Template", "Hostname", "Start time", "End time", "Worker"}
+	if explained(mode) {
+		header = append(header, "Execution time", "Chunks", "Cost")
+	}
+	if executed(mode) {
+		header = append(header, "Round trip", "Rows")
+	}
+	header = append(header, "Error")
+
+	b.WriteString("\n## Queries\n\n")
+	fmt.Fprintf(&b, "| %s |\n", strings.Join(header, " | "))
+	for _, column := range header {
+		fmt.Fprintf(&b, "|%s", strings.Repeat("-", len(column)+2))
+	}
+	b.WriteString("|\n")
+	for _, result := range report.Results {
+		q := newJSONQueryResult(result)
+		row := []string{q.Template, q.Hostname, q.StartTime.Format(csvTimeFormat), q.EndTime.Format(csvTimeFormat), strconv.Itoa(q.Worker)}
+		if explained(mode) {
+			chunks := ""
+			if q.Chunks != nil {
+				chunks = strconv.Itoa(*q.Chunks)
+				if report.Stats.Chunks.unexcluded(*q.Chunks, q.ChunksExcluded, q.StartTime, q.EndTime) {
+					chunks += " (not excluded)"
+				}
+			}
+			row = append(row, formatMillis(q.ExecutionTimeMillis), chunks, formatFloat(q.Cost))
+		}
+		if executed(mode) {
+			row = append(row, formatMillis(q.RoundTripMillis), strconv.Itoa(q.Rows))
+		}
+		row = append(row, strings.ReplaceAll(q.Error, "|", `\|`))
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(row, " | "))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var _ Reporter = CSVReporter{}
+
+// CSVReporter writes one CSV row of summary statistics per metric and worker, followed by an empty line
+// and one row per query. The global statistics are reported with the worker "ALL".
+type CSVReporter struct{}
+
+func (CSVReporter) reportsQueries() {}
+
+func (CSVReporter) Report(w io.Writer, report *Report) error {
+	out := csv.NewWriter(w)
+
+	header := append([]string{"metric", "worker"}, columnKeys()...)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, m := range report.Stats.Metrics() {
+		for worker := allWorkers; worker < m.Workers(); worker++ {
+			record := []string{m.Key, strconv.Itoa(worker)}
+			if worker == allWorkers {
+				record[1] = "ALL"
+			}
+			for _, x := range m.Row(worker) {
+				record = append(record, formatFloat(x))
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Failed queries are reported as the count of an "errors" metric without other statistics.
+	for worker := allWorkers; worker < len(report.Stats.Errors.ByWorker); worker++ {
+		record := make([]string, len(header))
+		record[0], record[1] = "errors", strconv.Itoa(worker)
+		if worker == allWorkers {
+			record[1] = "ALL"
+		}
+		record[2] = strconv.Itoa(report.Stats.Errors.Counts(worker).Total())
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Results) == 0 {
+		out.Flush()
+		return out.Error()
+	}
+
+	// The csv.Writer can't write an empty line, so the section of queries is separated by flushing first.
+	out.Flush()
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	if err := out.Write(csvQueryHeader); err != nil {
+		return err
+	}
+	for _, result := range report.Results {
+		q := newJSONQueryResult(result)
+		if err := out.Write([]string{
+			strconv.Itoa(q.Iteration), q.Template, q.Hostname, q.StartTime.Format(csvTimeFormat), q.EndTime.Format(csvTimeFormat),
+			strconv.Itoa(q.Worker), formatFloat(q.ExecutionTimeMillis), formatFloat(q.PlanningTimeMillis), formatFloat(q.Cost),
+			formatFloat(q.LatencyMillis), formatFloat(q.RoundTripMillis), strconv.Itoa(q.Rows), q.ErrorClass,
+		}); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// CSVQueryHeader is the header of the section of queries of a CSV report.
+var csvQueryHeader = []string{
+	"iteration", "template", "hostname", "start_time", "end_time", "worker", "execution_time_ms", "planning_time_ms",
+	"cost", "latency_ms", "round_trip_ms", "rows", "error_class",
+}
+
+var _ Reporter = JSONReporter{}
+
+// JSONReporter writes the statistics and every query result as an indented JSON document.
+type JSONReporter struct{}
+
+func (JSONReporter) reportsQueries() {}
+
+func (JSONReporter) Report(w io.Writer, report *Report) error {
+	doc := jsonReport{
+		Partial: report.Partial,
+		Mode:    report.Stats.Mode,
+		Stats:   newJSONStats(report.Stats),
+		Errors:  newJSONErrors(report.Stats.Errors),
+		Nodes:   newJSONNodes(report.Stats.Nodes),
+		Chunks:  newJSONChunks(report.Stats.Chunks),
+		Schedule: jsonSchedule{
+			Scheduler:      report.Scheduler,
+			MakespanMillis: durationMillis(float64(report.Stats.Makespan)),
+		},
+		Queries: make([]jsonQueryResult, 0, len(report.Results)),
+	}
+	if report.Warmup != nil {
+		doc.Warmup = &jsonWarmup{
+			Queries:        report.Warmup.Queries,
+			Errors:         report.Warmup.Errors,
+			DurationMillis: durationMillis(float64(report.Warmup.Duration)),
+		}
+	}
+	if report.Skipped != nil {
+		doc.Skipped = &jsonSkipped{report.Skipped.Rows, report.Skipped.Errors}
+	}
+	if len(report.Iterations) > 1 {
+		doc.AcrossIterations = map[string]map[string]jsonSampleSummary{}
+		for i, m := range report.Stats.Metrics() {
+			columns := map[string]jsonSampleSummary{}
+			for j, s := range report.AcrossIterations(i) {
+				columns[columnKeys()[j]] = jsonSampleSummary{s.Mean, s.StdDev, s.CI95}
+			}
+			doc.AcrossIterations[m.Key] = columns
+		}
+		for _, iteration := range report.Iterations {
+			doc.Iterations = append(doc.Iterations, newJSONStats(iteration))
+		}
+	}
+	for worker, busy := range report.Stats.Busy {
+		doc.Schedule.Workers = append(doc.Schedule.Workers, jsonWorkerTime{
+			BusyMillis: durationMillis(float64(busy)),
+			IdleMillis: durationMillis(float64(report.Stats.Idle(worker))),
+		})
+	}
+	for _, result := range report.Results {
+		q := newJSONQueryResult(result)
+		q.NoChunkExclusion = q.Chunks != nil && report.Stats.Chunks.unexcluded(*q.Chunks, q.ChunksExcluded, q.StartTime, q.EndTime)
+		doc.Queries = append(doc.Queries, q)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// JSONReport is the document written by JSONReporter.
+// Durations are reported in milliseconds.
+type jsonReport struct {
+	Partial          bool                                    `json:"partial"`
+	Mode             string                                  `json:"mode,omitempty"`
+	Warmup           *jsonWarmup                             `json:"warmup,omitempty"`
+	Skipped          *jsonSkipped                            `json:"skipped,omitempty"`
+	Stats            jsonStats                               `json:"stats"`
+	Errors           jsonErrors                              `json:"errors"`
+	Nodes            map[string]jsonNodeStats                `json:"plan_nodes,omitempty"`
+	Chunks           *jsonChunks                             `json:"chunks,omitempty"`
+	Schedule         jsonSchedule                            `json:"schedule"`
+	Iterations       []jsonStats                             `json:"iterations,omitempty"`
+	AcrossIterations map[string]map[string]jsonSampleSummary `json:"across_iterations,omitempty"`
+	Queries          []jsonQueryResult                       `json:"queries"`
+}
+
+type jsonWarmup struct {
+	Queries        int     `json:"queries"`
+	Errors         int     `json:"errors"`
+	DurationMillis float64 `json:"duration_ms"`
+}
+
+type jsonSkipped struct {
+	Rows   int      `json:"rows"`
+	Errors []string `json:"errors"`
+}
+
+// JSONStats maps the Key of each Metric to its summary.
+type jsonStats map[string]jsonStatSet
+
+// JSONStatSet is a summary of one metric across all workers and for each worker.
+type jsonStatSet struct {
+	Global  jsonSummary   `json:"global"`
+	Workers []jsonSummary `json:"workers"`
+}
+
+type jsonSummary struct {
+	Count       int                `json:"count"`
+	Total       float64            `json:"total"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Avg         float64            `json:"avg"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// JSONErrors counts failed queries by error class across all workers and for each worker.
+type jsonErrors struct {
+	Global   jsonErrorCounts   `json:"global"`
+	Workers  []jsonErrorCounts `json:"workers"`
+	Examples map[string]string `json:"examples,omitempty"`
+}
+
+type jsonErrorCounts struct {
+	Total   int            `json:"total"`
+	ByClass map[string]int `json:"by_class"`
+}
+
+// JSONSchedule reports the makespan of the benchmark and the time each worker spent busy and idle.
+type jsonSchedule struct {
+	Scheduler      string           `json:"scheduler,omitempty"`
+	MakespanMillis float64          `json:"makespan_ms"`
+	Workers        []jsonWorkerTime `json:"workers"`
+}
+
+type jsonWorkerTime struct {
+	BusyMillis float64 `json:"busy_ms"`
+	IdleMillis float64 `json:"idle_ms"`
+}
+
+// JSONNodeStats summarize the plan nodes of a node type, excluding the work of their children.
+type jsonNodeStats struct {
+	Nodes            int     `json:"nodes"`
+	TimeMillis       float64 `json:"time_ms"`
+	PlanRows         int     `json:"plan_rows"`
+	ActualRows       int     `json:"actual_rows"`
+	SharedHitBlocks  int     `json:"shared_hit_blocks"`
+	SharedReadBlocks int     `json:"shared_read_blocks"`
+}
+
+// JSONChunks report the execution time of queries by the number of hypertable chunks they scanned.
+type jsonChunks struct {
+	Known             int               `json:"known"`
+	UnexcludedQueries int               `json:"unexcluded_queries"`
+	ByCount           []jsonChunksCount `json:"by_count"`
+}
+
+type jsonChunksCount struct {
+	Chunks              int         `json:"chunks"`
+	ExecutionTimeMillis jsonSummary `json:"execution_time_ms"`
+}
+
+type jsonSampleSummary struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	CI95   float64 `json:"ci95"`
+}
+
+type jsonQueryResult struct {
+	Iteration                 int       `json:"iteration"`
+	Template                  string    `json:"template"`
+	Tag                       string    `json:"tag,omitempty"`
+	Hostname                  string    `json:"hostname"`
+	StartTime                 time.Time `json:"start_time"`
+	EndTime                   time.Time `json:"end_time"`
+	Worker                    int       `json:"worker"`
+	ExecutionTimeMillis       float64   `json:"execution_time_ms"`
+	PlanningTimeMillis        float64   `json:"planning_time_ms"`
+	ServerExecutionTimeMillis float64   `json:"server_execution_time_ms,omitempty"`
+	Chunks                    *int      `json:"chunks,omitempty"`
+	ChunksExcluded            int       `json:"chunks_excluded,omitempty"`
+	NoChunkExclusion          bool      `json:"no_chunk_exclusion,omitempty"`
+	Cost                      float64   `json:"cost"`
+	Scheduled                 time.Time `json:"scheduled"`
+	LatencyMillis             float64   `json:"latency_ms"`
+	RoundTripMillis           float64   `json:"round_trip_ms,omitempty"`
+	Rows                      int       `json:"rows,omitempty"`
+	Bytes                     int64     `json:"bytes,omitempty"`
+	ExpectedRows              *int      `json:"expected_rows,omitempty"`
+	UnexpectedRows            bool      `json:"unexpected_rows,omitempty"`
+	OverheadMillis            float64   `json:"overhead_ms,omitempty"`
+	Attempts                  int       `json:"attempts"`
+	TimedOut                  bool      `json:"timed_out,omitempty"`
+	Error                     string    `json:"error,omitempty"`
+	ErrorClass                string    `json:"error_class,omitempty"`
+
+	Nodes    map[string]jsonNodeStats `json:"plan_nodes,omitempty"`
+	Settings map[string]string        `json:"settings,omitempty"`
+}
+
+func newJSONStats(b BenchmarkStats) jsonStats {
+	s := jsonStats{}
+	for _, m := range b.Metrics() {
+		set := jsonStatSet{
+			Global:  newJSONSummary(m.Row(allWorkers)),
+			Workers: make([]jsonSummary, m.Workers()),
+		}
+		for worker := range set.Workers {
+			set.Workers[worker] = newJSONSummary(m.Row(worker))
+		}
+		s[m.Key] = set
+	}
+	return s
+}
+
+func newJSONNodes(nodes map[string]device.NodeStats) map[string]jsonNodeStats {
+	if len(nodes) == 0 {
+		return nil
+	}
+	j := make(map[string]jsonNodeStats, len(nodes))
+	for nodeType, node := range nodes {
+		j[nodeType] = jsonNodeStats{
+			Nodes:            node.Nodes,
+			TimeMillis:       durationMillis(float64(node.Time)),
+			PlanRows:         node.PlanRows,
+			ActualRows:       node.ActualRows,
+			SharedHitBlocks:  node.SharedHitBlocks,
+			SharedReadBlocks: node.SharedReadBlocks,
+		}
+	}
+	return j
+}
+
+func newJSONChunks(c ChunkStats) *jsonChunks {
+	if len(c.ByCount) == 0 {
+		return nil
+	}
+	j := &jsonChunks{Known: len(c.Known), UnexcludedQueries: c.unexcludedQueries()}
+	for _, n := range c.counts() {
+		m := Metric{stats: WorkerStats[time.Duration]{Global: c.ByCount[n]}, scale: durationMillis}
+		j.ByCount = append(j.ByCount, jsonChunksCount{Chunks: n, ExecutionTimeMillis: newJSONSummary(m.Row(allWorkers))})
+	}
+	return j
+}
+
+func newJSONErrors(e ErrorStats) jsonErrors {
+	counts := func(c ErrorCounts) jsonErrorCounts {
+		j := jsonErrorCounts{Total: c.Total(), ByClass: map[string]int{}}
+		for _, class := range ErrorClasses {
+			j.ByClass[string(class)] = c[class]
+		}
+		return j
+	}
+	j := jsonErrors{
+		Global:  counts(e.Global),
+		Workers: make([]jsonErrorCounts, len(e.ByWorker)),
+	}
+	for worker, c := range e.ByWorker {
+		j.Workers[worker] = counts(c)
+	}
+	if len(e.Examples) > 0 {
+		j.Examples = map[string]string{}
+		for class, example := range e.Examples {
+			j.Examples[string(class)] = example
+		}
+	}
+	return j
+}
+
+// NewJSONSummary converts a row of a statistics table to a jsonSummary.
+func newJSONSummary(row []float64) jsonSummary {
+	s := jsonSummary{
+		Count:       int(row[0]),
+		Total:       row[1],
+		Min:         row[2],
+		Max:         row[3],
+		Avg:         row[4],
+		Percentiles: make(map[string]float64, len(Percentiles)),
+	}
+	for i, p := range Percentiles {
+		s.Percentiles[percentileLabel(p)] = row[5+i]
+	}
+	return s
+}
+
+func newJSONQueryResult(result *QueryExecutionResult) jsonQueryResult {
+	q := jsonQueryResult{
+		Iteration:     result.Iteration,
+		Template:      result.Query.Template(),
+		Tag:           specOf(result.Query).Tag,
+		ExpectedRows:  specOf(result.Query).ExpectedRows,
+		Hostname:      result.Query.Host(),
+		Worker:        result.Worker,
+		Scheduled:     result.Scheduled,
+		LatencyMillis: durationMillis(float64(result.Latency)),
+		Attempts:      result.Attempts,
+		TimedOut:      result.TimedOut,
+	}
+	q.StartTime, q.EndTime = result.Query.TimeRange()
+	if result.Stats != nil {
+		q.ExecutionTimeMillis = durationMillis(float64(result.Stats.ExecutionTime))
+		q.PlanningTimeMillis = durationMillis(float64(result.Stats.PlanningTime))
+		q.ServerExecutionTimeMillis = durationMillis(float64(result.Stats.ServerExecutionTime))
+		if executed(result.Mode) {
+			q.RoundTripMillis = durationMillis(float64(result.Stats.RoundTrip))
+			q.Rows = result.Stats.Rows
+			q.Bytes = result.Stats.Bytes
+			q.UnexpectedRows = q.ExpectedRows != nil && *q.ExpectedRows != q.Rows
+		}
+		if executed(result.Mode) && explained(result.Mode) {
+			q.OverheadMillis = durationMillis(float64(result.Stats.Overhead()))
+		}
+		q.Cost = float64(result.Stats.Cost)
+		q.Settings = result.Stats.Settings
+		if plan := result.planSummary(); plan != nil {
+			q.Nodes = newJSONNodes(plan.Nodes)
+			q.Chunks = &plan.Chunks
+			q.ChunksExcluded = plan.ChunksExcluded
+		}
+	}
+	if result.Error != nil {
+		q.Error = result.Error.Error()
+		q.ErrorClass = string(classifyError(result.Error))
+	}
+	return q
+}
+
+func formatFloat(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+func formatMillis(ms float64) string {
+	return time.Duration(ms * float64(time.Millisecond)).Round(time.Microsecond).String()
+}
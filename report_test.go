@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func testReport() *Report {
+	start := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	results := []*QueryExecutionResult{
+		{
+			Query:  &device.MinMaxCPUQuery{Hostname: "host_000001", StartTime: start, EndTime: start.Add(time.Hour)},
+			Stats:  &device.QueryStats{ExecutionTime: 2 * time.Millisecond, PlanningTime: time.Millisecond, Cost: 10},
+			Worker: 0,
+		},
+		{
+			Query:  &device.MinMaxCPUQuery{Hostname: "host_000002", StartTime: start, EndTime: start.Add(time.Hour)},
+			Stats:  &device.QueryStats{ExecutionTime: 4 * time.Millisecond, PlanningTime: 2 * time.Millisecond, Cost: 30},
+			Worker: 1,
+		},
+	}
+
+	ch := make(chan *QueryExecutionResult, len(results))
+	for _, result := range results {
+		ch <- result
+	}
+	close(ch)
+
+	return &Report{
+		Stats:   aggregateStats(2, true, ch),
+		Results: results,
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	report := testReport()
+	report.Results = append(report.Results, &QueryExecutionResult{
+		Query: &device.MinMaxCPUQuery{Hostname: "host_000003"},
+		Error: errors.New("connection refused"),
+	})
+
+	var buf bytes.Buffer
+	if err := (JSONReporter{}).Report(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("failed to decode JSON report:", err)
+	}
+
+	if global := doc.Stats["execution_time_ms"].Global; global.Count != 2 || global.Avg != 3 {
+		t.Errorf("expected 2 queries averaging 3ms but got %d averaging %f", global.Count, global.Avg)
+	}
+	if global := doc.Stats["planning_time_ms"].Global; global.Count != 2 || global.Total != 3 {
+		t.Errorf("expected 2 queries planned in 3ms but got %d in %f", global.Count, global.Total)
+	}
+	if n := len(doc.Stats["cost"].Workers); n != 2 {
+		t.Errorf("expected cost for 2 workers but got %d", n)
+	}
+	if p50 := doc.Stats["cost"].Global.Percentiles["p50"]; p50 != 20 {
+		t.Errorf("expected p50 cost 20 but got %f", p50)
+	}
+	if n := len(doc.Queries); n != 3 {
+		t.Fatalf("expected 3 query results but got %d", n)
+	}
+	if msg := doc.Queries[2].Error; msg != "connection refused" {
+		t.Errorf("expected query error to be reported but got %q", msg)
+	}
+}
+
+func TestCSVReporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (CSVReporter{}).Report(&buf, testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The section of queries has other columns than the summary statistics.
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal("failed to read CSV report:", err)
+	}
+
+	// Header, then ALL plus 2 workers for each of the 4 metrics and the error counts,
+	// then a header and a row for each of the 2 queries.
+	if n := len(records); n != 19 {
+		t.Fatalf("expected 19 records but got %d", n)
+	}
+	if header := strings.Join(records[0][:3], ","); header != "metric,worker,count" {
+		t.Errorf("unexpected header: %s", header)
+	}
+	if row := strings.Join(records[7][:4], ","); row != "cost,ALL,2,40" {
+		t.Errorf("unexpected cost row: %s", row)
+	}
+	if row := strings.Join(records[13][:3], ","); row != "errors,ALL,0" {
+		t.Errorf("unexpected errors row: %s", row)
+	}
+	if header := strings.Join(records[16][:3], ","); header != "iteration,template,hostname" {
+		t.Errorf("unexpected header of queries: %s", header)
+	}
+	if row := strings.Join(records[18][1:9], ","); row != "min_max,host_000002,2017-01-01 08:00:00,2017-01-01 09:00:00,1,4,2,30" {
+		t.Errorf("unexpected query row: %s", row)
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	for _, format := range []string{"text", "json", "csv", "markdown"} {
+		if _, err := NewReporter(format); err != nil {
+			t.Errorf("format %s: %s", format, err)
+		}
+	}
+	if _, err := NewReporter("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestPlanNodesReport(t *testing.T) {
+	plan := &device.PlanNode{
+		NodeType: "Sort", ActualTotalTime: 3, ActualLoops: 1, PlanRows: 10, ActualRows: 12,
+		Plans: []device.PlanNode{
+			{NodeType: "Seq Scan", ActualTotalTime: 2, ActualLoops: 1, PlanRows: 100, ActualRows: 90, SharedReadBlocks: 5},
+		},
+	}
+	report := testReport()
+	report.Results[0].Stats.Plan = plan
+	report.Results[1].Stats.Plan = plan
+	report.Stats = newBenchmarkStats(2, true)
+	for _, result := range report.Results {
+		report.Stats.Push(result)
+	}
+
+	scan := report.Stats.Nodes["Seq Scan"]
+	if scan.Nodes != 2 || scan.Time != 4*time.Millisecond || scan.ActualRows != 180 || scan.SharedReadBlocks != 10 {
+		t.Errorf("expected the scans of both queries to be aggregated but got %+v", scan)
+	}
+	if types := report.Stats.nodeTypes(); strings.Join(types, ",") != "Seq Scan,Sort" {
+		t.Errorf("expected node types by descending time but got %v", types)
+	}
+
+	var text bytes.Buffer
+	if err := (TextReporter{}).Report(&text, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "Plan nodes:") || !strings.Contains(text.String(), " 66.7% ") {
+		t.Errorf("expected a plan nodes table with the share of time of the scans but got:\n%s", text.String())
+	}
+
+	var buf bytes.Buffer
+	if err := (JSONReporter{}).Report(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("failed to decode JSON report:", err)
+	}
+	if sort := doc.Nodes["Sort"]; sort.Nodes != 2 || sort.TimeMillis != 2 {
+		t.Errorf("expected 2 sorts taking 2ms but got %+v", sort)
+	}
+	if scan := doc.Queries[0].Nodes["Seq Scan"]; scan.Nodes != 1 || scan.PlanRows != 100 || scan.ActualRows != 90 {
+		t.Errorf("expected the scan of the first query but got %+v", scan)
+	}
+}
+
+func TestChunksReport(t *testing.T) {
+	scan := func(chunks ...string) *device.PlanNode {
+		plan := &device.PlanNode{NodeType: "Custom Scan", CustomPlanProvider: "ChunkAppend"}
+		for _, chunk := range chunks {
+			plan.Plans = append(plan.Plans, device.PlanNode{NodeType: "Index Scan", RelationName: chunk})
+		}
+		return plan
+	}
+	start := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	query := func(offset, length time.Duration) *device.MinMaxCPUQuery {
+		return &device.MinMaxCPUQuery{Hostname: "host_000001", StartTime: start.Add(offset), EndTime: start.Add(offset + length)}
+	}
+	all := scan("_hyper_1_1_chunk", "_hyper_1_2_chunk", "_hyper_1_3_chunk")
+	excluding := scan("_hyper_1_1_chunk", "_hyper_1_2_chunk", "_hyper_1_3_chunk")
+	excluding.ChunksExcludedDuringRuntime = 2
+	report := &Report{
+		Stats: newBenchmarkStats(1, true),
+		Results: []*QueryExecutionResult{
+			{Query: query(0, time.Hour), Stats: &device.QueryStats{ExecutionTime: time.Millisecond, Plan: scan("_hyper_1_1_chunk")}},
+			{Query: query(time.Hour, time.Hour), Stats: &device.QueryStats{ExecutionTime: 3 * time.Millisecond, Plan: scan("_hyper_1_2_chunk")}},
+			{Query: query(30*time.Minute, time.Hour), Stats: &device.QueryStats{ExecutionTime: 9 * time.Millisecond, Plan: all}},
+			// A query of the whole span needs every chunk, and the chunks of another were excluded at runtime.
+			{Query: query(0, 3*time.Hour), Stats: &device.QueryStats{ExecutionTime: 12 * time.Millisecond, Plan: all}},
+			{Query: query(0, time.Hour), Stats: &device.QueryStats{ExecutionTime: 6 * time.Millisecond, Plan: excluding}},
+		},
+	}
+	for _, result := range report.Results {
+		report.Stats.Push(result)
+	}
+
+	var buf bytes.Buffer
+	if err := (JSONReporter{}).Report(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonReport
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("failed to decode JSON report:", err)
+	}
+
+	if doc.Chunks == nil || doc.Chunks.Known != 3 || doc.Chunks.UnexcludedQueries != 1 || len(doc.Chunks.ByCount) != 2 {
+		t.Fatalf("expected 3 known chunks and 1 query without exclusion but got %+v", doc.Chunks)
+	}
+	if one := doc.Chunks.ByCount[0]; one.Chunks != 1 || one.ExecutionTimeMillis.Count != 2 || one.ExecutionTimeMillis.Avg != 2 {
+		t.Errorf("expected 2 queries of 1 chunk averaging 2ms but got %+v", one)
+	}
+	for i, q := range doc.Queries {
+		if q.NoChunkExclusion != (i == 2) {
+			t.Errorf("expected only the narrow query of every chunk to be flagged but query %d was %t: %+v", i, q.NoChunkExclusion, q)
+		}
+	}
+	if q := doc.Queries[2]; q.Chunks == nil || *q.Chunks != 3 {
+		t.Errorf("expected the query to scan 3 chunks but got %+v", q)
+	}
+
+	var md bytes.Buffer
+	if err := (MarkdownReporter{}).Report(&md, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "| 9ms | 3 (not excluded) |") {
+		t.Errorf("expected the chunk count next to the execution time but got:\n%s", md.String())
+	}
+
+	// A narrow query is flagged even if only one chunk is known.
+	chunks := newChunkStats(false)
+	chunks.Push([]string{"_hyper_1_1_chunk"}, 0, start, start.Add(2*time.Hour), time.Millisecond)
+	chunks.Push([]string{"_hyper_1_1_chunk"}, 0, start, start.Add(time.Hour), time.Millisecond)
+	if n := chunks.unexcludedQueries(); n != 1 {
+		t.Errorf("expected 1 query without exclusion of a single chunk but got %d", n)
+	}
+}
+
+func TestMarkdownReporterModes(t *testing.T) {
+	for mode, expect := range map[string]string{
+		ModeExplain: "| Template | Hostname | Start time | End time | Worker | Execution time | Chunks | Cost | Error |",
+		ModeExec:    "| Template | Hostname | Start time | End time | Worker | Round trip | Rows | Error |",
+		ModeBoth:    "| Template | Hostname | Start time | End time | Worker | Execution time | Chunks | Cost | Round trip | Rows | Error |",
+	} {
+		report := testReport()
+		report.Stats.Mode = mode
+		for _, result := range report.Results {
+			result.Mode = mode
+			result.Stats.RoundTrip, result.Stats.Rows = 5*time.Millisecond, 2
+		}
+
+		var md bytes.Buffer
+		if err := (MarkdownReporter{}).Report(&md, report); err != nil {
+			t.Fatal(err)
+		}
+		_, queries, _ := strings.Cut(md.String(), "## Queries\n\n")
+		lines := strings.Split(queries, "\n")
+		if lines[0] != expect {
+			t.Errorf("expected the %s columns %q but got %q", mode, expect, lines[0])
+		}
+		if cells := strings.Count(lines[2], "|"); cells != strings.Count(expect, "|") {
+			t.Errorf("expected a cell of each %s column but got %q", mode, lines[2])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultMaxBackoff is the longest delay between attempts of a RetryPolicy without a MaxBackoff.
+const DefaultMaxBackoff = 10 * time.Second
+
+// RetryPolicy retries queries that failed with a transient error, such as a serialization failure
+// or a lost connection. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a query is executed before its error is reported, including the first.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt, which doubles for every following attempt.
+	Backoff time.Duration
+
+	// MaxBackoff limits the delay between attempts. Optional, defaults to DefaultMaxBackoff.
+	MaxBackoff time.Duration
+}
+
+// Retry returns whether a query that failed with err should be executed again, after the given number of attempts.
+func (p RetryPolicy) retry(attempts int, err error) bool {
+	return attempts < p.MaxAttempts && isRetryable(err)
+}
+
+// Delay returns the delay before the next attempt, after the given number of attempts.
+// The delay grows exponentially, and is jittered between half and all of it so that
+// workers that failed together don't retry together.
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	d := p.Backoff
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsRetryable returns whether a query error is transient, so the query may succeed if it is executed again.
+// Timeouts are not retryable, since a query that is too slow is expected to be as slow again.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01": // deadlock_detected
+			return true
+		}
+	}
+	return classifyError(err) == ErrorConnection
+}
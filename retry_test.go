@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{8, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.delay(test.attempts); d < test.max/2 || d > test.max {
+				t.Fatalf("expected the delay after %d attempts to be between %s and %s but got %s", test.attempts, test.max/2, test.max, d)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+
+	tests := []struct {
+		err      error
+		attempts int
+		want     bool
+	}{
+		{&pq.Error{Code: "40001"}, 1, true},
+		{&pq.Error{Code: "40P01"}, 2, true},
+		{&pq.Error{Code: "08006"}, 1, true},
+		{&pq.Error{Code: "40001"}, 3, false},
+		{&pq.Error{Code: "42601"}, 1, false},
+		{&pq.Error{Code: "57014"}, 1, false},
+		{errors.New("unexpected"), 1, false},
+	}
+	for _, test := range tests {
+		if got := p.retry(test.attempts, test.err); got != test.want {
+			t.Errorf("expected retry of %v after %d attempts to be %t", test.err, test.attempts, test.want)
+		}
+	}
+
+	if (RetryPolicy{}).retry(1, &pq.Error{Code: "40001"}) {
+		t.Error("expected the zero RetryPolicy never to retry")
+	}
+}
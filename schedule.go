@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Arrivals returns the interval until the next query arrives in an open-loop benchmark.
+type Arrivals func() time.Duration
+
+// ConstantArrivals returns Arrivals at fixed intervals for a rate in queries per second.
+func ConstantArrivals(rate float64) Arrivals {
+	interval := time.Duration(float64(time.Second) / rate)
+	return func() time.Duration {
+		return interval
+	}
+}
+
+// PoissonArrivals returns Arrivals of a Poisson process for a rate in queries per second.
+// The intervals are exponentially distributed with a mean of 1/rate.
+func PoissonArrivals(rate float64, r *rand.Rand) Arrivals {
+	return func() time.Duration {
+		return time.Duration(r.ExpFloat64() / rate * float64(time.Second))
+	}
+}
+
+// NewArrivals returns the Arrivals of the named arrival process ("poisson" or "constant").
+func NewArrivals(process string, rate float64) (Arrivals, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be greater than zero (received: %g)", rate)
+	}
+	switch process {
+	case "poisson":
+		return PoissonArrivals(rate, rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	case "constant":
+		return ConstantArrivals(rate), nil
+	}
+	return nil, fmt.Errorf("unknown arrival process %q (expected poisson or constant)", process)
+}
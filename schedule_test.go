@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestPoissonArrivals(t *testing.T) {
+	const rate = 200.0
+
+	arrivals := PoissonArrivals(rate, rand.New(rand.NewSource(1)))
+
+	var total time.Duration
+	for i := 0; i < 10000; i++ {
+		total += arrivals()
+	}
+
+	mean := total.Seconds() / 10000
+	if expect := 1 / rate; math.Abs(mean-expect)/expect > 0.05 {
+		t.Errorf("expected mean interval %fs but got %fs", expect, mean)
+	}
+}
+
+func TestScheduleOpenLoop(t *testing.T) {
+	queries := []device.Query{
+		&device.MinMaxCPUQuery{Hostname: "host_000000"},
+		&device.MinMaxCPUQuery{Hostname: "host_000001"},
+		&device.MinMaxCPUQuery{Hostname: "host_000002"},
+	}
+	assignments := []int{0, 1, 0}
+	queues := []chan *queryJob{make(chan *queryJob, 10), make(chan *queryJob, 10)}
+
+	start := time.Now()
+	scheduleOpenLoop(context.Background(), queries, assignments, ConstantArrivals(1000), time.Time{}, channelQueues(queues))
+
+	var jobs []*queryJob
+	for job := range queues[0] {
+		jobs = append(jobs, job)
+	}
+	if n := len(jobs); n != 2 {
+		t.Fatalf("expected 2 jobs for worker 0 but got %d", n)
+	}
+	if host := jobs[1].Query.Host(); host != "host_000002" {
+		t.Errorf("expected second job for worker 0 to be host_000002 but got %s", host)
+	}
+	// The third arrival is scheduled 3ms after the start.
+	if offset := jobs[1].Scheduled.Sub(start); offset < 3*time.Millisecond || offset > 4*time.Millisecond {
+		t.Errorf("expected third arrival 3ms after start but got %s", offset)
+	}
+	if n := len(queues[1]); n != 1 {
+		t.Errorf("expected 1 job for worker 1 but got %d", n)
+	}
+}
+
+func TestScheduleClosedLoopDeadline(t *testing.T) {
+	queries := []device.Query{&device.MinMaxCPUQuery{Hostname: "host_000000"}}
+	jobs := make(chan *queryJob)
+
+	go scheduleClosedLoop(context.Background(), queries, time.Now().Add(20*time.Millisecond), jobs)
+
+	n := 0
+	for job := range jobs {
+		if !job.Scheduled.IsZero() {
+			t.Error("closed-loop jobs should not be scheduled")
+		}
+		n++
+		time.Sleep(time.Millisecond)
+	}
+	if n < 2 {
+		t.Errorf("expected the query to repeat until the deadline but it ran %d times", n)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// Schedulers are the strategies for distributing queries to workers.
+const (
+	// SchedulerHash sends each query to the worker its host is assigned to, so every query of a host
+	// is executed by the same worker.
+	SchedulerHash = "hash"
+
+	// SchedulerShared sends every query to a single queue, from which any idle worker takes the next one.
+	SchedulerShared = "shared"
+
+	// SchedulerSteal queues each query for the worker its host is assigned to, like SchedulerHash,
+	// but an idle worker takes queries from the queue with the most queries left.
+	SchedulerSteal = "steal"
+)
+
+// Schedulers are the names of all schedulers.
+var Schedulers = []string{SchedulerHash, SchedulerShared, SchedulerSteal}
+
+// ValidateScheduler returns an error if the scheduler name is unknown.
+func validateScheduler(scheduler string) error {
+	for _, s := range Schedulers {
+		if s == scheduler {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown scheduler %q (expected hash, shared or steal)", scheduler)
+}
+
+// NextJob returns the next job of a worker, or false when there are no more jobs.
+type nextJob func() (*queryJob, bool)
+
+// JobQueues receive the jobs scheduled for each bucket of an open-loop benchmark.
+type jobQueues interface {
+	// Send queues a job for a bucket, and returns false if the context was cancelled first.
+	send(ctx context.Context, bucket int, job *queryJob) bool
+
+	// Close signals that no more jobs will be sent.
+	close()
+}
+
+// ChannelQueues are a job channel per bucket.
+type channelQueues []chan *queryJob
+
+func (q channelQueues) send(ctx context.Context, bucket int, job *queryJob) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case q[bucket] <- job:
+		return true
+	}
+}
+
+func (q channelQueues) close() {
+	for _, queue := range q {
+		close(queue)
+	}
+}
+
+// SharedQueue is a single job channel for every bucket.
+type sharedQueue chan *queryJob
+
+func (q sharedQueue) send(ctx context.Context, _ int, job *queryJob) bool {
+	return channelQueues{q}.send(ctx, 0, job)
+}
+
+func (q sharedQueue) close() {
+	close(q)
+}
+
+// StealingQueues are an unbounded job queue per bucket, where a worker takes jobs from its own bucket
+// while it has any, and otherwise steals the last job of the bucket with the most jobs left.
+type stealingQueues struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buckets [][]*queryJob
+	closed  bool
+
+	// Refilling is true while a worker is calling refill, so other idle workers wait for its jobs.
+	refilling bool
+
+	// Refill is called when every queue is empty and the queues aren't closed, to queue more jobs
+	// in a closed-loop benchmark. It returns false if there are no more jobs. Optional.
+	refill func() bool
+}
+
+func newStealingQueues(numBuckets int) *stealingQueues {
+	q := &stealingQueues{buckets: make([][]*queryJob, numBuckets)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues a job for a bucket without blocking.
+func (q *stealingQueues) push(bucket int, job *queryJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.buckets[bucket] = append(q.buckets[bucket], job)
+	q.cond.Broadcast()
+}
+
+func (q *stealingQueues) send(_ context.Context, bucket int, job *queryJob) bool {
+	q.push(bucket, job)
+	return true
+}
+
+func (q *stealingQueues) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Take returns the next job of a worker, waiting until a job is queued or the queues are closed.
+func (q *stealingQueues) take(worker int) (*queryJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if own := q.buckets[worker]; len(own) > 0 {
+			q.buckets[worker] = own[1:]
+			return own[0], true
+		}
+
+		busiest := worker
+		for bucket, jobs := range q.buckets {
+			if len(jobs) > len(q.buckets[busiest]) {
+				busiest = bucket
+			}
+		}
+		if jobs := q.buckets[busiest]; len(jobs) > 0 {
+			q.buckets[busiest] = jobs[:len(jobs)-1]
+			return jobs[len(jobs)-1], true
+		}
+
+		if q.closed {
+			return nil, false
+		}
+		if q.refill != nil && !q.refilling {
+			q.refilling = true
+			q.mu.Unlock()
+			more := q.refill()
+			q.mu.Lock()
+			q.refilling = false
+			if !more {
+				q.closed = true
+			}
+			q.cond.Broadcast()
+			continue
+		}
+		q.cond.Wait()
+	}
+}
+
+// Schedule starts sending the jobs of a workload according to the Scheduler,
+// and returns a function that takes the next job for each worker.
+// The jobs stop when the workload is done or the context is cancelled, and done is closed after every worker stopped.
+func (c *BenchmarkCommand) schedule(ctx context.Context, w workload, deadline time.Time, done <-chan struct{}) []nextJob {
+	if w.stream != nil {
+		return c.scheduleStream(ctx, w.stream)
+	}
+
+	sources := make([]nextJob, c.Concurrency)
+
+	switch c.Scheduler {
+	case SchedulerShared:
+		queue := make(sharedQueue, c.queueSize())
+		for worker := range sources {
+			sources[worker] = receive(queue)
+		}
+		if c.arrivals != nil {
+			go scheduleOpenLoop(ctx, w.queries, w.assignments, c.arrivals, deadline, queue)
+		} else {
+			go scheduleClosedLoop(ctx, w.queries, deadline, queue)
+		}
+
+	case SchedulerSteal:
+		queues := newStealingQueues(c.Concurrency)
+		for worker := range sources {
+			worker := worker
+			sources[worker] = func() (*queryJob, bool) { return queues.take(worker) }
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				queues.close()
+			case <-done:
+			}
+		}()
+		if c.arrivals != nil {
+			go scheduleOpenLoop(ctx, w.queries, w.assignments, c.arrivals, deadline, queues)
+		} else {
+			// Queue one pass of the workload at a time, repeating it until the deadline passes.
+			pass := func() bool {
+				if ctx.Err() != nil || (!deadline.IsZero() && time.Now().After(deadline)) {
+					return false
+				}
+				for i, query := range w.queries {
+					queues.push(w.assignments[i], &queryJob{Query: query})
+				}
+				return len(w.queries) > 0
+			}
+			pass()
+			if !deadline.IsZero() {
+				queues.refill = pass
+			} else {
+				queues.close()
+			}
+		}
+
+	default:
+		queues := make(channelQueues, c.Concurrency)
+		for worker := range queues {
+			queues[worker] = make(chan *queryJob, c.queueSize())
+			sources[worker] = receive(queues[worker])
+		}
+		if c.arrivals != nil {
+			go scheduleOpenLoop(ctx, w.queries, w.assignments, c.arrivals, deadline, queues)
+		} else {
+			buckets := make([][]device.Query, c.Concurrency)
+			for i, query := range w.queries {
+				buckets[w.assignments[i]] = append(buckets[w.assignments[i]], query)
+			}
+			for bucket, queries := range buckets {
+				go scheduleClosedLoop(ctx, queries, deadline, queues[bucket])
+			}
+		}
+	}
+
+	return sources
+}
+
+// QueueSize returns the capacity of job channels.
+// Open-loop queues are buffered so the scheduler isn't held up by a busy worker.
+func (c *BenchmarkCommand) queueSize() int {
+	if c.arrivals != nil {
+		return openLoopQueueSize
+	}
+	return 0
+}
+
+// Receive returns the jobs received from a channel until it is closed.
+func receive(jobs <-chan *queryJob) nextJob {
+	return func() (*queryJob, bool) {
+		job, ok := <-jobs
+		return job, ok
+	}
+}
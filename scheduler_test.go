@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestStealingQueuesTake(t *testing.T) {
+	q := newStealingQueues(3)
+	for i := 0; i < 3; i++ {
+		q.push(0, &queryJob{Query: &device.MinMaxCPUQuery{Hostname: "host_000000"}})
+	}
+	q.push(1, &queryJob{Query: &device.MinMaxCPUQuery{Hostname: "host_000001"}})
+	q.close()
+
+	// Worker 1 takes its own job first, then steals from the busiest bucket.
+	if job, _ := q.take(1); job.Query.Host() != "host_000001" {
+		t.Errorf("expected worker 1 to take its own job first but got %s", job.Query.Host())
+	}
+	if job, _ := q.take(1); job.Query.Host() != "host_000000" {
+		t.Errorf("expected worker 1 to steal from worker 0 but got %s", job.Query.Host())
+	}
+	if job, _ := q.take(2); job.Query.Host() != "host_000000" {
+		t.Errorf("expected worker 2 to steal from worker 0 but got %s", job.Query.Host())
+	}
+	if _, ok := q.take(0); !ok {
+		t.Error("expected worker 0 to take its last job")
+	}
+	if _, ok := q.take(2); ok {
+		t.Error("expected no jobs after the queues are drained")
+	}
+}
+
+func TestBenchmarkCommandSchedulers(t *testing.T) {
+	for _, scheduler := range Schedulers {
+		t.Run(scheduler, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal("failed to init sqlmock:", err)
+			}
+			expectExplain(mock, 3)
+
+			report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Scheduler: scheduler}, testCSVData)
+
+			if n := report.Stats["execution_time_ms"].Global.Count; n != 3 {
+				t.Errorf("expected 3 queries in stats but got %d", n)
+			}
+			if report.Schedule.Scheduler != scheduler || len(report.Schedule.Workers) != 2 {
+				t.Errorf("expected the schedule of 2 workers to be reported but got %+v", report.Schedule)
+			}
+			if report.Schedule.MakespanMillis <= 0 {
+				t.Error("expected a makespan to be reported")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
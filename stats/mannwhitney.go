@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// MannWhitneyResult is the outcome of a Mann-Whitney U test.
+type MannWhitneyResult struct {
+	// U is the U statistic of the first sample.
+	U float64
+
+	// Z is the standard score of U. It is positive when values of the first sample tend to be larger.
+	Z float64
+
+	// P is the two-sided p-value: the probability of a difference at least this large
+	// if both samples were drawn from the same distribution.
+	P float64
+}
+
+// MannWhitneyU tests whether two independent samples are drawn from the same distribution,
+// without assuming the values are normally distributed. The p-value uses the normal approximation
+// with a correction for ties, which is accurate when both samples have more than about 20 values.
+func MannWhitneyU(a, b []float64) MannWhitneyResult {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return MannWhitneyResult{P: 1}
+	}
+
+	type value struct {
+		x     float64
+		first bool
+	}
+	values := make([]value, 0, len(a)+len(b))
+	for _, x := range a {
+		values = append(values, value{x, true})
+	}
+	for _, x := range b {
+		values = append(values, value{x, false})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].x < values[j].x })
+
+	// Sum the ranks of the first sample, giving tied values the average of their ranks.
+	var rankSum, ties float64
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].x == values[i].x {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if values[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n := n1 + n2
+	u := rankSum - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return MannWhitneyResult{U: u, P: 1}
+	}
+
+	// Apply a continuity correction towards the mean.
+	diff := u - mean
+	switch {
+	case diff > 0.5:
+		diff -= 0.5
+	case diff < -0.5:
+		diff += 0.5
+	default:
+		diff = 0
+	}
+	z := diff / sigma
+
+	return MannWhitneyResult{
+		U: u,
+		Z: z,
+		P: math.Erfc(math.Abs(z) / math.Sqrt2),
+	}
+}
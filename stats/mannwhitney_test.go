@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	// U counts the pairs where a value of the first sample is larger: only 5 > 4.
+	a := []float64{1, 2, 3, 5}
+	b := []float64{4, 6, 7, 8, 9}
+
+	result := MannWhitneyU(a, b)
+	if result.U != 1 {
+		t.Errorf("expected U = 1 but got %f", result.U)
+	}
+	if result.Z >= 0 {
+		t.Errorf("expected a negative Z for a smaller first sample but got %f", result.Z)
+	}
+}
+
+func TestMannWhitneyUSignificance(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	sample := func(n int, mean float64) []float64 {
+		xs := make([]float64, n)
+		for i := range xs {
+			xs[i] = math.Exp(r.NormFloat64()*0.3) * mean
+		}
+		return xs
+	}
+
+	same := MannWhitneyU(sample(200, 10), sample(200, 10))
+	if same.P < 0.01 {
+		t.Errorf("expected no significant difference between identical distributions but got p = %f", same.P)
+	}
+
+	slower := MannWhitneyU(sample(200, 10), sample(200, 12))
+	if slower.P > 0.001 {
+		t.Errorf("expected a significant difference for a 20%% shift but got p = %f", slower.P)
+	}
+	if slower.Z >= 0 {
+		t.Errorf("expected a negative Z when the second sample is larger but got %f", slower.Z)
+	}
+
+	ties := MannWhitneyU([]float64{1, 1, 1}, []float64{1, 1})
+	if ties.P != 1 {
+		t.Errorf("expected p = 1 for identical values but got %f", ties.P)
+	}
+}
@@ -0,0 +1,177 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultRelativeAccuracy is the relative error bound used by NewAggregator.
+const DefaultRelativeAccuracy = 0.01
+
+// Quantiles estimates quantiles of a set of values as they are pushed over time.
+type Quantiles[T Divisible] interface {
+	// Push adds a value to the set.
+	Push(x T)
+
+	// Quantile returns the value at quantile q, where 0 <= q <= 1.
+	// If the set is empty, 0 is returned.
+	Quantile(q float64) float64
+}
+
+var _ Quantiles[int] = (*Sketch[int])(nil)
+
+// Sketch is a streaming quantile estimator with bounded memory and a relative error of alpha, based on DDSketch.
+type Sketch[T Divisible] struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+
+	// Positive and negative count values by bucket index. Negative values are
+	// indexed by their absolute value.
+	positive map[int]int
+	negative map[int]int
+	zero     int
+	count    int
+}
+
+// NewSketch returns a Sketch with relative accuracy alpha, which must be between 0 and 1.
+func NewSketch[T Divisible](alpha float64) *Sketch[T] {
+	if alpha <= 0 || alpha >= 1 {
+		panic("stats: sketch relative accuracy must be between 0 and 1")
+	}
+	gamma := (1 + alpha) / (1 - alpha)
+	return &Sketch[T]{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: map[int]int{},
+		negative: map[int]int{},
+	}
+}
+
+// RelativeAccuracy returns the relative error bound α of the sketch.
+func (s *Sketch[T]) RelativeAccuracy() float64 {
+	return s.alpha
+}
+
+// Push adds a value to the set.
+func (s *Sketch[T]) Push(x T) {
+	v := float64(x)
+	switch {
+	case v > 0:
+		s.positive[s.index(v)]++
+	case v < 0:
+		s.negative[s.index(-v)]++
+	default:
+		s.zero++
+	}
+	s.count++
+}
+
+// Merge adds every value counted by other to s.
+// Both sketches must have the same relative accuracy.
+func (s *Sketch[T]) Merge(other *Sketch[T]) {
+	if s.alpha != other.alpha {
+		panic("stats: cannot merge sketches with different relative accuracy")
+	}
+	for i, n := range other.positive {
+		s.positive[i] += n
+	}
+	for i, n := range other.negative {
+		s.negative[i] += n
+	}
+	s.zero += other.zero
+	s.count += other.count
+}
+
+// Quantile returns an estimate of the value at quantile q, where 0 <= q <= 1.
+func (s *Sketch[T]) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int(clamp(q, 0, 1) * float64(s.count-1))
+
+	// Walk the buckets in ascending order of value until the rank is reached:
+	// negative buckets by descending index, then zero, then positive buckets by ascending index.
+	seen := 0
+	for _, i := range sortedKeys(s.negative, true) {
+		seen += s.negative[i]
+		if seen > rank {
+			return -s.value(i)
+		}
+	}
+	seen += s.zero
+	if seen > rank {
+		return 0
+	}
+	for _, i := range sortedKeys(s.positive, false) {
+		seen += s.positive[i]
+		if seen > rank {
+			return s.value(i)
+		}
+	}
+	return 0
+}
+
+// Index returns the bucket index of a positive value.
+func (s *Sketch[T]) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// Value returns the representative value of a bucket, which is within α of every value in the bucket.
+func (s *Sketch[T]) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+var _ Quantiles[int] = (*Exact[int])(nil)
+
+// Exact computes exact quantiles by keeping every value in memory.
+// It is suitable for small sets of values; use a Sketch for large or unbounded sets.
+type Exact[T Divisible] struct {
+	values []T
+	sorted bool
+}
+
+func NewExact[T Divisible]() *Exact[T] {
+	return &Exact[T]{}
+}
+
+// Push adds a value to the set.
+func (e *Exact[T]) Push(x T) {
+	e.values = append(e.values, x)
+	e.sorted = false
+}
+
+// Quantile returns the value at quantile q, where 0 <= q <= 1, interpolating linearly
+// between the two closest ranks. Quantile(0.5) is the median.
+func (e *Exact[T]) Quantile(q float64) float64 {
+	if len(e.values) == 0 {
+		return 0
+	}
+	if !e.sorted {
+		sort.Slice(e.values, func(i, j int) bool { return e.values[i] < e.values[j] })
+		e.sorted = true
+	}
+	pos := clamp(q, 0, 1) * float64(len(e.values)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	frac := pos - float64(lo)
+	return float64(e.values[lo]) + frac*(float64(e.values[hi])-float64(e.values[lo]))
+}
+
+func sortedKeys(m map[int]int, descending bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+func clamp(x, min, max float64) float64 {
+	return math.Max(min, math.Min(max, x))
+}
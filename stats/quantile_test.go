@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestExactQuantile(t *testing.T) {
+	e := NewExact[int]()
+
+	for _, x := range []int{9, -1, 8, 2, 7, 3} {
+		e.Push(x)
+	}
+
+	if median := e.Quantile(0.5); median != 5 {
+		t.Errorf("expected median 5 but got %0.1f", median)
+	}
+	if min := e.Quantile(0); min != -1 {
+		t.Errorf("expected minimum -1 but got %0.1f", min)
+	}
+	if max := e.Quantile(1); max != 9 {
+		t.Errorf("expected maximum 9 but got %0.1f", max)
+	}
+}
+
+func TestSketchRelativeError(t *testing.T) {
+	const alpha = 0.01
+
+	s := NewSketch[float64](alpha)
+	e := NewExact[float64]()
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		// Log-normal values spanning several orders of magnitude, like query latencies.
+		x := math.Exp(r.NormFloat64()*2 + 5)
+		s.Push(x)
+		e.Push(x)
+	}
+
+	for _, q := range []float64{0, 0.5, 0.9, 0.95, 0.99, 0.999, 1} {
+		// The sketch uses the lower rank, so compare against the exact value at that rank.
+		rank := math.Floor(q*9999) / 9999
+		expect := e.Quantile(rank)
+		actual := s.Quantile(q)
+		if err := math.Abs(actual-expect) / expect; err > alpha {
+			t.Errorf("quantile %0.3f: expected %f within %0.2f but got %f (error %0.4f)", q, expect, alpha, actual, err)
+		}
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a := NewSketch[int](0.01)
+	b := NewSketch[int](0.01)
+
+	for i := 1; i <= 50; i++ {
+		a.Push(i)
+		b.Push(-i)
+	}
+	b.Push(0)
+	a.Merge(b)
+
+	if median := a.Quantile(0.5); median != 0 {
+		t.Errorf("expected median 0 but got %f", median)
+	}
+	if min := a.Quantile(0); math.Abs(min+50) > 0.5 {
+		t.Errorf("expected minimum near -50 but got %f", min)
+	}
+}
+
+func TestAggregatorPercentile(t *testing.T) {
+	a := NewExactAggregator[int]()
+
+	for i := 1; i <= 100; i++ {
+		a.Push(i)
+	}
+
+	if p := a.Percentile(99); math.Abs(p-99.01) > 1e-9 {
+		t.Errorf("expected p99 99.01 but got %f", p)
+	}
+	if med := a.Median(); med != 50.5 {
+		t.Errorf("expected median 50.5 but got %f", med)
+	}
+	if empty := (&Aggregator[int]{}).Percentile(50); empty != 0 {
+		t.Errorf("expected 0 for an empty aggregator but got %f", empty)
+	}
+}
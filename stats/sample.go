@@ -0,0 +1,65 @@
+package stats
+
+import "math"
+
+// SampleSummary describes a small sample of measurements, such as a statistic repeated across benchmark runs.
+type SampleSummary struct {
+	N    int
+	Mean float64
+
+	// StdDev is the sample standard deviation, with Bessel's correction.
+	StdDev float64
+
+	// CI95 is the half-width of the 95% confidence interval of the mean, based on Student's t-distribution.
+	// The interval is Mean ± CI95. It is zero if the sample has fewer than 2 values.
+	CI95 float64
+}
+
+// Summarize returns the mean, standard deviation and confidence interval of a sample.
+func Summarize(xs []float64) SampleSummary {
+	s := SampleSummary{N: len(xs)}
+	if s.N == 0 {
+		return s
+	}
+
+	for _, x := range xs {
+		s.Mean += x
+	}
+	s.Mean /= float64(s.N)
+
+	if s.N < 2 {
+		return s
+	}
+
+	var ss float64
+	for _, x := range xs {
+		ss += (x - s.Mean) * (x - s.Mean)
+	}
+	s.StdDev = math.Sqrt(ss / float64(s.N-1))
+	s.CI95 = tCritical95(s.N-1) * s.StdDev / math.Sqrt(float64(s.N))
+
+	return s
+}
+
+// TCritical95 returns the two-tailed critical value of Student's t-distribution at 95% confidence
+// for the degrees of freedom df, which must be at least 1.
+func tCritical95(df int) float64 {
+	table := []float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+
+	// Beyond the table, interpolate linearly in 1/df between the usual entries, which is accurate to about 0.001.
+	tail := []struct{ df, t float64 }{{30, 2.042}, {40, 2.021}, {60, 2.000}, {120, 1.980}, {math.Inf(1), 1.960}}
+	for i := 1; i < len(tail); i++ {
+		if lo, hi := tail[i-1], tail[i]; float64(df) <= hi.df {
+			f := (1/lo.df - 1/float64(df)) / (1/lo.df - 1/hi.df)
+			return lo.t + f*(hi.t-lo.t)
+		}
+	}
+	return 1.960
+}
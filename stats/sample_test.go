@@ -0,0 +1,36 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+
+	if s.N != 8 || s.Mean != 5 {
+		t.Errorf("expected 8 values with mean 5 but got %d with mean %f", s.N, s.Mean)
+	}
+	if expect := math.Sqrt(32.0 / 7); math.Abs(s.StdDev-expect) > 1e-9 {
+		t.Errorf("expected standard deviation %f but got %f", expect, s.StdDev)
+	}
+	if expect := 2.365 * s.StdDev / math.Sqrt(8); math.Abs(s.CI95-expect) > 1e-9 {
+		t.Errorf("expected confidence interval ±%f but got ±%f", expect, s.CI95)
+	}
+
+	if single := Summarize([]float64{3}); single.Mean != 3 || single.StdDev != 0 || single.CI95 != 0 {
+		t.Errorf("expected a single value to have no deviation but got %+v", single)
+	}
+	if empty := Summarize(nil); empty.N != 0 || empty.Mean != 0 {
+		t.Errorf("expected an empty summary but got %+v", empty)
+	}
+}
+
+func TestTCritical95(t *testing.T) {
+	// Reference values of the two-tailed critical value at 95% confidence.
+	for df, expect := range map[int]float64{1: 12.706, 30: 2.042, 40: 2.021, 45: 2.014, 90: 1.987, 200: 1.972, 100000: 1.960} {
+		if got := tCritical95(df); math.Abs(got-expect) > 0.001 {
+			t.Errorf("expected %.3f for %d degrees of freedom but got %.4f", expect, df, got)
+		}
+	}
+}
@@ -1,21 +1,30 @@
 package stats
 
 // Aggregator continuously tracks the count, total, minimum value, maximum value, average value
-// and median value of a set of values as they are passed to the aggregator over time.
+// and percentiles of a set of values as they are passed to the aggregator over time.
 type Aggregator[T Divisible] struct {
 	Count int
 	Total T
 	Min   T
 	Max   T
 	Avg   float64
-	Med   float64
 
-	median *Median[T]
+	quantiles Quantiles[T]
 }
 
+// NewAggregator returns an Aggregator that estimates percentiles with a Sketch,
+// using bounded memory with a relative error of DefaultRelativeAccuracy.
 func NewAggregator[T Divisible]() *Aggregator[T] {
 	return &Aggregator[T]{
-		median: NewMedian[T](),
+		quantiles: NewSketch[T](DefaultRelativeAccuracy),
+	}
+}
+
+// NewExactAggregator returns an Aggregator that computes exact percentiles.
+// Every value is kept in memory, so it should only be used for small sets of values.
+func NewExactAggregator[T Divisible]() *Aggregator[T] {
+	return &Aggregator[T]{
+		quantiles: NewExact[T](),
 	}
 }
 
@@ -34,7 +43,21 @@ func (a *Aggregator[T]) Push(x T) {
 
 	a.Avg = float64(a.Total) / float64(a.Count)
 
-	a.median.Push(x)
+	if a.quantiles != nil {
+		a.quantiles.Push(x)
+	}
+}
+
+// Percentile returns the value at percentile p, where 0 <= p <= 100.
+// If no values have been pushed, 0 is returned.
+func (a *Aggregator[T]) Percentile(p float64) float64 {
+	if a.quantiles == nil {
+		return 0
+	}
+	return a.quantiles.Quantile(p / 100)
+}
 
-	a.Med = a.median.Median()
+// Median returns the median value of the set.
+func (a *Aggregator[T]) Median() float64 {
+	return a.Percentile(50)
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// StreamQueueSize is the capacity of each worker's job queue when queries are streamed,
+// which bounds the number of queries read ahead of the workers.
+const streamQueueSize = 64
+
+// QueryStream feeds queries to the workers while they are read from the input, so the input is never held in memory.
+type queryStream struct {
+	reader queryReader
+
+	// Balancer assigns each query to a bucket as it is read.
+	balancer Balancer
+
+	// Err is the error that stopped reading the input, or nil if the whole input was read.
+	err error
+
+	// Done is closed once the stream stops reading the input, or is nil if the stream hasn't started.
+	done chan struct{}
+}
+
+// Wait waits until the stream stops reading the input, after which its reader and err can be read.
+func (s *queryStream) wait() {
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// ValidateStream returns an error if an option needs every query before the benchmark starts,
+// or needs to read them more than once.
+func (c *BenchmarkCommand) validateStream() error {
+	switch {
+	case c.Duration > 0:
+		return errors.New("streamed queries can't be replayed for a duration")
+	case c.Iterations > 1:
+		return errors.New("streamed queries can't be replayed for more than one iteration")
+	case c.WarmupQueries > 0 || c.WarmupDuration > 0:
+		return errors.New("streamed queries can't be replayed for a warm-up")
+	case c.Balancer == "lpt":
+		return errors.New("the lpt balancer needs every query before the benchmark, so it can't balance streamed queries")
+	case c.Scheduler == SchedulerSteal:
+		return errors.New("the steal scheduler queues every query without bounds, so it can't schedule streamed queries")
+	}
+	return nil
+}
+
+// ScheduleStream starts feeding the streamed queries to a job queue per worker, or to a single queue with the shared Scheduler.
+func (c *BenchmarkCommand) scheduleStream(ctx context.Context, stream *queryStream) []nextJob {
+	sources := make([]nextJob, c.Concurrency)
+
+	size := streamQueueSize
+	if c.arrivals != nil {
+		size = openLoopQueueSize
+	}
+
+	var queues jobQueues
+	if c.Scheduler == SchedulerShared {
+		queue := make(sharedQueue, size)
+		for worker := range sources {
+			sources[worker] = receive(queue)
+		}
+		queues = queue
+	} else {
+		perWorker := make(channelQueues, c.Concurrency)
+		for worker := range perWorker {
+			perWorker[worker] = make(chan *queryJob, size)
+			sources[worker] = receive(perWorker[worker])
+		}
+		queues = perWorker
+	}
+
+	stream.done = make(chan struct{})
+	go stream.feed(ctx, c.Concurrency, c.arrivals, queues)
+
+	return sources
+}
+
+// Feed sends each query to the queue of its bucket until the input ends, reading fails or the context is cancelled.
+func (s *queryStream) feed(ctx context.Context, buckets int, arrivals Arrivals, queues jobQueues) {
+	defer close(s.done)
+	defer queues.close()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	next := time.Now()
+
+	for ctx.Err() == nil {
+		query, err := s.reader.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		bucket, err := s.balancer(query, buckets)
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		job := &queryJob{Query: query}
+
+		if arrivals != nil {
+			next = next.Add(arrivals())
+			if wait := time.Until(next); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			}
+			job.Scheduled = next
+		}
+
+		if !queues.send(ctx, bucket, job) {
+			return
+		}
+	}
+}
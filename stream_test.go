@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestBenchmarkCommandStream(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 6)
+
+	data := testCSVData + strings.Join(strings.Split(testCSVData, "\n")[1:], "\n")
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Stream: true}, data)
+
+	if n := len(report.Queries); n != 6 {
+		t.Fatalf("expected 6 streamed queries but got %d", n)
+	}
+
+	// Every query of a host is executed by the worker the hash balancer assigns the host to.
+	balancer, err := NewBalancer("hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range report.Queries {
+		bucket, err := balancer(&device.MinMaxCPUQuery{Hostname: q.Hostname}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Worker != bucket {
+			t.Errorf("expected %s to be executed by worker %d but got %d", q.Hostname, bucket, q.Worker)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBenchmarkCommandStreamError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	expectExplain(mock, 3)
+
+	data := testCSVData + "host_000004,yesterday,2017-01-02 19:50:28\nhost_000005,2017-01-02 18:50:28,2017-01-02 19:50:28\n"
+
+	var out bytes.Buffer
+	cmd := &BenchmarkCommand{
+		CSV:         testCSVFile(t, data),
+		DB:          db,
+		Concurrency: 1,
+		Stream:      true,
+		Reporter:    JSONReporter{},
+		Output:      &out,
+		Log:         io.Discard,
+	}
+	err = cmd.Exec(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Fatalf("expected an error of line 5 but got %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal("failed to decode report:", err)
+	}
+	if !report.Partial {
+		t.Error("expected a partial report")
+	}
+	if n := len(report.Queries); n != 3 {
+		t.Errorf("expected the 3 queries before the failing line to be executed but got %d", n)
+	}
+}
+
+func TestBenchmarkCommandStreamCancel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 1000; i++ {
+		mock.ExpectQuery("EXPLAIN").WillDelayFor(time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(testPlanJSON))
+	}
+
+	data := "hostname,start_time,end_time\n" + strings.Repeat(strings.Join(strings.Split(testCSVData, "\n")[1:], "\n"), 333)
+
+	// Cancel while the stream is still reading, so the workers stop before it does. Every query has a warning,
+	// so the stream counts them until it stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := &BenchmarkCommand{CSV: testCSVFile(t, data), DB: db, Concurrency: 2, Stream: true, Reporter: JSONReporter{},
+		Validation: Validation{MaxRange: time.Minute}, Output: &out, Log: io.Discard}
+	if err := cmd.Exec(ctx); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected the benchmark to be interrupted but got %v", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal("failed to decode report:", err)
+	}
+	if !report.Partial || len(report.Queries) >= 999 {
+		t.Errorf("expected a partial report but got %d queries", len(report.Queries))
+	}
+}
+
+func TestValidateStream(t *testing.T) {
+	for _, cmd := range []*BenchmarkCommand{
+		{Duration: 1},
+		{Iterations: 2},
+		{WarmupQueries: 1},
+		{Balancer: "lpt"},
+		{Scheduler: SchedulerSteal},
+	} {
+		if err := cmd.validateStream(); err == nil {
+			t.Errorf("expected streaming to be rejected for %+v", cmd)
+		}
+	}
+	if err := (&BenchmarkCommand{Scheduler: SchedulerShared, Balancer: "ring"}).validateStream(); err != nil {
+		t.Error(err)
+	}
+}
+
+// CaptureReporter keeps the report it was asked to write.
+type captureReporter struct {
+	report *Report
+}
+
+func (r *captureReporter) Report(_ io.Writer, report *Report) error {
+	r.report = report
+	return nil
+}
+
+func (r *captureReporter) captured() *Report {
+	return r.report
+}
+
+// CaptureQueriesReporter keeps the report it was asked to write, and asks for every query result.
+type captureQueriesReporter struct {
+	captureReporter
+}
+
+func (*captureQueriesReporter) reportsQueries() {}
+
+func TestBenchmarkCommandCollectsResults(t *testing.T) {
+	for _, capture := range []interface {
+		Reporter
+		captured() *Report
+	}{&captureReporter{}, &captureQueriesReporter{}} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("failed to init sqlmock:", err)
+		}
+		expectExplain(mock, 3)
+
+		cmd := &BenchmarkCommand{CSV: testCSVFile(t, testCSVData), DB: db, Concurrency: 2, Stream: true,
+			Reporter: capture, Log: io.Discard}
+		if err := cmd.Exec(context.Background()); err != nil {
+			t.Fatal("benchmark failed:", err)
+		}
+
+		report := capture.captured()
+		if report.Stats.Latency.Global.Count != 3 {
+			t.Errorf("expected 3 queries in stats but got %d", report.Stats.Latency.Global.Count)
+		}
+
+		// The span of the chunk statistics is the span of the time ranges of the queries executed.
+		if start, end := report.Stats.Chunks.Start.Format(csvTimeFormat), report.Stats.Chunks.End.Format(csvTimeFormat); start != "2017-01-01 08:59:22" || end != "2017-01-02 19:50:28" {
+			t.Errorf("expected the span of the queries but got %s to %s", start, end)
+		}
+		if !reportsQueries(capture) {
+			if len(report.Results) != 0 {
+				t.Errorf("expected no results to be collected for a reporter of statistics but got %d", len(report.Results))
+			}
+			continue
+		}
+		if len(report.Results) != 3 {
+			t.Fatalf("expected 3 results to be collected but got %d", len(report.Results))
+		}
+		for _, result := range report.Results {
+			if result.Stats.Plan != nil || result.Plan == nil || len(result.Plan.Nodes) == 0 {
+				t.Errorf("expected the plan tree to be replaced by a summary but got %+v and %+v", result.Stats.Plan, result.Plan)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// DefaultMaxRange is the longest time range of a query before a warning, unless a Validation sets MaxRange.
+const DefaultMaxRange = 7 * 24 * time.Hour
+
+// MaxLoggedIssues is the number of issues logged by the pre-flight validation of a benchmark.
+const maxLoggedIssues = 10
+
+// Severities of a ValidationIssue.
+const (
+	// SeverityError is an issue of a query that can't be benchmarked meaningfully, which fails the pre-flight of a benchmark.
+	SeverityError = "error"
+
+	// SeverityWarning is an issue of a query that may be intended, but is likely to skew the results.
+	SeverityWarning = "warning"
+)
+
+// Checks of a Validation.
+const (
+	CheckInvertedRange  = "inverted_range"
+	CheckZeroRange      = "zero_range"
+	CheckLongRange      = "long_range"
+	CheckDuplicate      = "duplicate"
+	CheckUnknownHost    = "unknown_host"
+	CheckOutsideDataset = "outside_dataset"
+)
+
+// Validation checks query specifications before they are executed.
+// The zero value checks the time range and hostname of each query, and finds duplicate queries.
+type Validation struct {
+	// Hosts is the number of hosts of the dataset, whose IDs are 0 to Hosts-1. Optional, if 0 only the
+	// format of hostnames is checked.
+	Hosts int
+
+	// Start and End are the bounds of the time range of the dataset. Optional, if either is zero the time
+	// ranges of queries aren't compared to the dataset.
+	Start, End time.Time
+
+	// MaxRange is the longest time range of a query before a warning. Optional, defaults to DefaultMaxRange.
+	MaxRange time.Duration
+}
+
+// ValidationIssue is a problem found with a query by a Validation.
+type ValidationIssue struct {
+	// Query is the index of the query in its input.
+	Query int
+
+	Severity string
+	Check    string
+	Message  string
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("query %d: %s: %s", i.Query+1, i.Severity, i.Message)
+}
+
+// Validate checks every query, and returns the issues found in the order of the queries.
+func (v Validation) Validate(queries []device.Query) []ValidationIssue {
+	issues := make([]ValidationIssue, 0)
+	seen := map[string]int{}
+
+	for i, query := range queries {
+		issues = append(issues, v.check(i, query)...)
+
+		key := duplicateKey(query)
+		if first, ok := seen[key]; ok {
+			issues = append(issues, ValidationIssue{i, SeverityWarning, CheckDuplicate, fmt.Sprintf("duplicate of query %d", first+1)})
+		} else {
+			seen[key] = i
+		}
+	}
+
+	return issues
+}
+
+// DuplicateKey identifies a query by its template and args.
+// String args are quoted, so that adjacent strings can't run together into the args of another query.
+func duplicateKey(query device.Query) string {
+	var b strings.Builder
+	b.WriteString(query.Template())
+	for _, arg := range query.Args() {
+		if s, ok := arg.(string); ok {
+			fmt.Fprintf(&b, " %q", s)
+		} else {
+			fmt.Fprintf(&b, " %v", arg)
+		}
+	}
+	return b.String()
+}
+
+// Check returns the issues of a single query, which is every check except for duplicates.
+func (v Validation) check(i int, query device.Query) []ValidationIssue {
+	var issues []ValidationIssue
+	issue := func(severity, check, format string, a ...any) {
+		issues = append(issues, ValidationIssue{i, severity, check, fmt.Sprintf(format, a...)})
+	}
+
+	if host := query.Host(); host != "" {
+		if id, err := device.ParseHostID(host); err != nil || id < 0 {
+			issue(SeverityError, CheckUnknownHost, "hostname %q doesn't match the format host_000000", host)
+		} else if v.Hosts > 0 && id >= v.Hosts {
+			issue(SeverityWarning, CheckUnknownHost, "host %s isn't one of the %d hosts of the dataset", host, v.Hosts)
+		}
+	}
+
+	if start, end := query.TimeRange(); !start.IsZero() || !end.IsZero() {
+		v.checkRange(start, end, issue)
+	}
+
+	return issues
+}
+
+func (v Validation) checkRange(start, end time.Time, issue func(severity, check, format string, a ...any)) {
+	switch length := end.Sub(start); {
+	case length < 0:
+		issue(SeverityError, CheckInvertedRange, "end time %s is before start time %s", end.Format(csvTimeFormat), start.Format(csvTimeFormat))
+		return
+	case length == 0:
+		issue(SeverityWarning, CheckZeroRange, "time range is empty, start and end time are %s", start.Format(csvTimeFormat))
+		return
+	case length > v.maxRange():
+		issue(SeverityWarning, CheckLongRange, "time range of %s is longer than %s", length, v.maxRange())
+	}
+
+	if v.Start.IsZero() || v.End.IsZero() {
+		return
+	}
+	if !end.After(v.Start) || !start.Before(v.End) {
+		issue(SeverityWarning, CheckOutsideDataset, "time range is entirely outside the dataset, so no rows will be scanned")
+	} else if start.Before(v.Start) || end.After(v.End) {
+		issue(SeverityWarning, CheckOutsideDataset, "time range extends beyond the dataset")
+	}
+}
+
+func (v Validation) maxRange() time.Duration {
+	if v.MaxRange <= 0 {
+		return DefaultMaxRange
+	}
+	return v.MaxRange
+}
+
+// CountIssues returns the number of errors and warnings.
+func countIssues(issues []ValidationIssue) (errs, warnings int) {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	return errs, warnings
+}
+
+// WriteIssues writes the number of errors and warnings found, and up to max of the issues, or every issue if max is 0.
+func writeIssues(w io.Writer, queries, errs, warnings int, issues []ValidationIssue, max int) {
+	fmt.Fprintf(w, "Validated %d queries: %d errors, %d warnings\n", queries, errs, warnings)
+	shown := issues
+	if max > 0 && len(shown) > max {
+		shown = shown[:max]
+	}
+	for _, issue := range shown {
+		fmt.Fprintf(w, "  %s\n", issue)
+	}
+	if more := errs + warnings - len(shown); more > 0 {
+		fmt.Fprintf(w, "  ...and %d more\n", more)
+	}
+}
+
+// ValidatedQueries validates each query of a stream as it is read. Only the checks of a single query are made,
+// since finding duplicates would hold every query in memory.
+type validatedQueries struct {
+	reader     queryReader
+	validation Validation
+
+	// Lenient skips a query with an error, and keep executes it. Otherwise reading stops with the error.
+	lenient, keep bool
+
+	// Read is the number of queries read, and errors and warnings count their issues.
+	read, errors, warnings int
+
+	// Issues are the first issues found, up to maxLoggedIssues.
+	issues []ValidationIssue
+
+	skips skippedRows
+}
+
+func (qs *validatedQueries) next() (device.Query, error) {
+	for {
+		query, err := qs.reader.next()
+		if err != nil {
+			return nil, err
+		}
+		i := qs.read
+		qs.read++
+
+		var failed *ValidationIssue
+		for _, issue := range qs.validation.check(i, query) {
+			issue := issue
+			if issue.Severity == SeverityError {
+				qs.errors++
+				if failed == nil {
+					failed = &issue
+				}
+			} else {
+				qs.warnings++
+			}
+			if len(qs.issues) < maxLoggedIssues {
+				qs.issues = append(qs.issues, issue)
+			}
+		}
+
+		switch {
+		case failed == nil || qs.keep:
+			return query, nil
+		case qs.lenient:
+			qs.skips.skip(errors.New(failed.String()))
+		default:
+			return nil, fmt.Errorf("validation failed: %s", failed)
+		}
+	}
+}
+
+// Skipped returns the rows skipped by the reader, followed by the queries skipped because of an error.
+func (qs *validatedQueries) skipped() skippedRows {
+	s := qs.reader.skipped()
+	for _, err := range qs.skips.examples {
+		s.skip(err)
+	}
+	s.count += qs.skips.count - len(qs.skips.examples)
+	return s
+}
+
+// ValidationFlags defines the flags of a Validation, and returns a function that reads them once they are parsed.
+func validationFlags(flags *flag.FlagSet) func() (Validation, error) {
+	hosts := flags.Int("hosts", 0, "number of hosts of the dataset, to warn of queries of other hosts (defaults to checking the hostname format only)")
+	start := flags.String("dataset-start", "", "start time of the dataset, to warn of queries outside it, e.g. 2017-01-01 00:00:00")
+	end := flags.String("dataset-end", "", "end time of the dataset, to warn of queries outside it, e.g. 2017-01-02 00:00:00")
+	maxRange := flags.Duration("max-range", DefaultMaxRange, "longest time range of a query before a warning")
+
+	return func() (v Validation, err error) {
+		v = Validation{Hosts: *hosts, MaxRange: *maxRange}
+		if v.Start, err = parseDatasetBound("dataset-start", *start); err != nil {
+			return v, err
+		}
+		if v.End, err = parseDatasetBound("dataset-end", *end); err != nil {
+			return v, err
+		}
+		if !v.Start.IsZero() && !v.End.IsZero() && !v.End.After(v.Start) {
+			return v, errors.New("-dataset-end must be after -dataset-start")
+		}
+		return v, nil
+	}
+}
+
+// ParseDatasetBound parses the value of a flag of a dataset bound, or returns the zero time if it is empty.
+func parseDatasetBound(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := CSVOptions{TimeLayouts: []string{csvTimeFormat, LayoutRFC3339}}.parseTime(value)
+	if err != nil {
+		return t, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sbward/ts-query-workers/device"
+)
+
+// ValidateCommand checks a file of query specifications for issues with the Validation, without executing
+// any queries, and prints every issue found. It fails if any issue is an error.
+type ValidateCommand struct {
+	// CSV is the source of query specifications to validate, in the InputFormat.
+	CSV io.Reader
+
+	// InputFormat is the format of the query specifications: "csv" or "jsonl". Optional, defaults to
+	// "jsonl" if the CSV is a file with a .jsonl or .ndjson extension, and "csv" otherwise.
+	InputFormat string
+
+	// CSVOptions configure how times are read from a CSV file. Optional.
+	CSVOptions CSVOptions
+
+	// Template builds the query for each query specification. Optional, defaults to device.MinMaxCPUTemplate.
+	Template device.Template
+
+	// Validation configures the checks of each query. Optional.
+	Validation Validation
+
+	// Output is the destination of the issues. Optional, defaults to stdout.
+	Output io.Writer
+}
+
+// NewValidateCommandFromCLI reads the configuration of a ValidateCommand from the arguments following "validate".
+func NewValidateCommandFromCLI(args []string) (*ValidateCommand, error) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	format := flags.String("input-format", "", "format of the query specifications: csv or jsonl (defaults to jsonl for .jsonl files, csv otherwise)")
+	template := flags.String("template", "min_max", "name of a built-in query template, or path to a SQL template file")
+	timeLayout := flags.String("time-layout", csvTimeFormat, "comma-separated layouts of CSV times, tried in order: a Go time layout, rfc3339, unix or unix_ms")
+	timezone := flags.String("timezone", "UTC", "IANA time zone of CSV times without an offset, e.g. America/New_York")
+	validation := validationFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ts-query-workers validate [options] [CSV_OR_JSONL_FILENAME]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	tmpl, err := loadTemplate(*template)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", *timezone, err)
+	}
+	v, err := validation()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &ValidateCommand{
+		CSV:         os.Stdin,
+		InputFormat: *format,
+		CSVOptions:  CSVOptions{TimeLayouts: strings.Split(*timeLayout, ","), Location: loc},
+		Template:    tmpl,
+		Validation:  v,
+	}
+
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		cmd.CSV = f
+	}
+
+	return cmd, nil
+}
+
+// Exec validates every query and prints the issues found.
+func (c *ValidateCommand) Exec(ctx context.Context) error {
+	if closer, ok := c.CSV.(io.Closer); ok && c.CSV != os.Stdin {
+		defer closer.Close()
+	}
+
+	var filename string
+	if f, ok := c.CSV.(*os.File); ok {
+		filename = f.Name()
+	}
+	format, err := inputFormat(c.InputFormat, filename)
+	if err != nil {
+		return err
+	}
+
+	tmpl := c.Template
+	if tmpl == nil {
+		tmpl = device.MinMaxCPUTemplate
+	}
+
+	queries, err := queriesFromInput(c.CSV, format, c.CSVOptions, tmpl, defaultBucketSize("1m"))
+	if err != nil {
+		return err
+	}
+
+	out := c.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	issues := c.Validation.Validate(queries)
+	errs, warnings := countIssues(issues)
+	writeIssues(out, len(queries), errs, warnings, issues, 0)
+
+	if errs > 0 {
+		return fmt.Errorf("validation failed with %d errors", errs)
+	}
+	return nil
+}
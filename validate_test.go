@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sbward/ts-query-workers/device"
+)
+
+func TestValidation(t *testing.T) {
+	start := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	query := func(host string, start time.Time, length time.Duration) device.Query {
+		return device.MinMaxCPUQuery{BucketSize: "1m", Hostname: host, StartTime: start, EndTime: start.Add(length)}
+	}
+
+	v := Validation{Hosts: 10, Start: start, End: start.Add(24 * time.Hour), MaxRange: 12 * time.Hour}
+	issues := v.Validate([]device.Query{
+		query("host_000001", start, time.Hour),
+		query("host_000001", start.Add(time.Hour), -time.Hour),
+		query("host_000002", start, 0),
+		query("host_000001", start, time.Hour),
+		query("web-1", start, time.Hour),
+		query("host_000010", start, time.Hour),
+		query("host_000003", start.Add(-48*time.Hour), time.Hour),
+		query("host_000004", start.Add(23*time.Hour), 2*time.Hour),
+		query("host_000005", start, 13*time.Hour),
+	})
+
+	expect := []ValidationIssue{
+		{Query: 1, Severity: SeverityError, Check: CheckInvertedRange},
+		{Query: 2, Severity: SeverityWarning, Check: CheckZeroRange},
+		{Query: 3, Severity: SeverityWarning, Check: CheckDuplicate, Message: "duplicate of query 1"},
+		{Query: 4, Severity: SeverityError, Check: CheckUnknownHost},
+		{Query: 5, Severity: SeverityWarning, Check: CheckUnknownHost},
+		{Query: 6, Severity: SeverityWarning, Check: CheckOutsideDataset, Message: "time range is entirely outside the dataset, so no rows will be scanned"},
+		{Query: 7, Severity: SeverityWarning, Check: CheckOutsideDataset, Message: "time range extends beyond the dataset"},
+		{Query: 8, Severity: SeverityWarning, Check: CheckLongRange},
+	}
+	if len(issues) != len(expect) {
+		t.Fatalf("expected %d issues but got %d: %v", len(expect), len(issues), issues)
+	}
+	for i, issue := range issues {
+		e := expect[i]
+		if issue.Query != e.Query || issue.Severity != e.Severity || issue.Check != e.Check || (e.Message != "" && issue.Message != e.Message) {
+			t.Errorf("expected issue %d to be %+v but got %+v", i, e, issue)
+		}
+	}
+
+	if errs, warnings := countIssues(issues); errs != 2 || warnings != 6 {
+		t.Errorf("expected 2 errors and 6 warnings but got %d and %d", errs, warnings)
+	}
+
+	// Queries whose string args only match when they run together aren't duplicates.
+	if issues := (Validation{}).Validate([]device.Query{
+		device.MinMaxCPUQuery{BucketSize: "1m", Hostname: "host_000001", StartTime: start, EndTime: start.Add(time.Hour)},
+		device.MinMaxCPUQuery{BucketSize: "1mhost_00000", Hostname: "1", StartTime: start, EndTime: start.Add(time.Hour)},
+	}); len(issues) != 0 {
+		t.Errorf("expected no duplicates but got %v", issues)
+	}
+
+	// Without a dataset, queries are only checked for hostnames, ranges and duplicates.
+	if issues := (Validation{}).Validate([]device.Query{query("host_000010", start.Add(-48*time.Hour), time.Hour)}); len(issues) != 0 {
+		t.Errorf("expected no issues without a dataset but got %v", issues)
+	}
+}
+
+// ArgsQuery is a query with args of any type.
+type argsQuery struct {
+	device.MinMaxCPUQuery
+	args []any
+}
+
+func (q argsQuery) Args() []any {
+	return q.args
+}
+
+func TestValidationDuplicateArgs(t *testing.T) {
+	start := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	query := func(args ...any) device.Query {
+		return argsQuery{device.MinMaxCPUQuery{Hostname: "host_000001", StartTime: start, EndTime: start.Add(time.Hour)}, args}
+	}
+
+	issues := (Validation{}).Validate([]device.Query{
+		query("host_000001", start, 10, 1.5),
+		query("host_000001", start.Add(time.Hour), 10, 1.5),
+		query("host_000001", start, 0x110000, 1.5),
+		query("host_000001", start, 0x110001, 1.5),
+		query("host_000001", start, 10, 2.5),
+		query("host_000001", time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC), 10, 1.5),
+	})
+
+	// Only the last query has the same args as another one, even though %q formats ints beyond Unicode alike.
+	if len(issues) != 1 || issues[0].Query != 5 || issues[0].Message != "duplicate of query 1" {
+		t.Errorf("expected query 6 to be the only duplicate but got %v", issues)
+	}
+	if key := duplicateKey(query("host_000001", start, 10, 1.5)); key != `min_max "host_000001" 2017-01-01 08:00:00 +0000 UTC 10 1.5` {
+		t.Errorf("expected each arg to be formatted by its type but got %s", key)
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := &ValidateCommand{CSV: strings.NewReader(csvSampleData), Output: &out}
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Validated 200 queries: 0 errors") {
+		t.Errorf("expected the sample data to be valid but got:\n%s", out.String())
+	}
+
+	out.Reset()
+	cmd = &ValidateCommand{CSV: strings.NewReader(testCSVData + "host_000004,2017-01-02 19:50:28,2017-01-02 18:50:28\n"), Output: &out}
+	err := cmd.Exec(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 errors") {
+		t.Errorf("expected validation to fail with 1 error but got %v", err)
+	}
+	if !strings.Contains(out.String(), "query 4: error: end time 2017-01-02 18:50:28 is before start time 2017-01-02 19:50:28") {
+		t.Errorf("expected the inverted range to be reported but got:\n%s", out.String())
+	}
+}
+
+func TestBenchmarkCommandValidation(t *testing.T) {
+	data := testCSVData + "web-1,2017-01-02 18:50:28,2017-01-02 19:50:28\n"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	var log bytes.Buffer
+	cmd := &BenchmarkCommand{CSV: testCSVFile(t, data), DB: db, Concurrency: 2, Output: &bytes.Buffer{}, Log: &log}
+	if err := cmd.Exec(context.Background()); err == nil || !strings.Contains(err.Error(), "validation failed") {
+		t.Errorf("expected the benchmark to fail validation but got %v", err)
+	}
+	if !strings.Contains(log.String(), `query 4: error: hostname "web-1" doesn't match`) {
+		t.Errorf("expected the issue to be logged but got:\n%s", log.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	expectExplain(mock, 4)
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, SkipValidation: true}, data)
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 4 {
+		t.Errorf("expected 4 queries in stats when skipping validation but got %d", n)
+	}
+}
+
+func TestBenchmarkCommandStreamValidation(t *testing.T) {
+	data := strings.Replace(testCSVData, "end_time\n", "end_time\nweb-1,2017-01-02 18:50:28,2017-01-02 19:50:28\n", 1) +
+		"host_000004,2017-01-02 18:50:28,2017-01-02 18:50:28\n"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("failed to init sqlmock:", err)
+	}
+
+	var log bytes.Buffer
+	cmd := &BenchmarkCommand{CSV: testCSVFile(t, data), Stream: true, DB: db, Concurrency: 2, Output: &bytes.Buffer{}, Log: &log}
+	if err := cmd.Exec(context.Background()); err == nil || !strings.Contains(err.Error(), `validation failed: query 1: error: hostname "web-1" doesn't match`) {
+		t.Errorf("expected the stream to stop at the invalid query but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// A lenient stream skips the query with an error, and still executes the query with a warning.
+	expectExplain(mock, 4)
+	log.Reset()
+	report := runJSONBenchmark(t, &BenchmarkCommand{DB: db, Concurrency: 2, Stream: true, CSVOptions: CSVOptions{Lenient: true}}, data)
+	if n := report.Stats["execution_time_ms"].Global.Count; n != 4 {
+		t.Errorf("expected 4 queries in stats but got %d", n)
+	}
+	if report.Skipped.Rows != 1 || len(report.Skipped.Errors) != 1 || !strings.Contains(report.Skipped.Errors[0], "web-1") {
+		t.Errorf("expected the invalid query to be skipped but got %+v", report.Skipped)
+	}
+
+	expectExplain(mock, 5)
+	cmd = &BenchmarkCommand{CSV: testCSVFile(t, data), Stream: true, SkipValidation: true, DB: db, Concurrency: 2, Output: &bytes.Buffer{}, Log: &log}
+	if err := cmd.Exec(context.Background()); err != nil {
+		t.Fatal("benchmark failed:", err)
+	}
+	if !strings.Contains(log.String(), "Validated 5 queries: 1 errors, 1 warnings\n  query 1: error") ||
+		!strings.Contains(log.String(), "query 5: warning: time range is empty") {
+		t.Errorf("expected the issues to be logged but got:\n%s", log.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}